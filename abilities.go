@@ -0,0 +1,218 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 渠道没有任何模型通过测试时的处理方式
+const (
+	EmptyPolicyKeep    = "keep"    // 保留原有模型
+	EmptyPolicyDisable = "disable" // 保留原有模型并自动禁用渠道，恢复后重新启用
+	EmptyPolicyClear   = "clear"   // 清空模型
+)
+
+// 模型不可用时abilities行的处理方式
+const (
+	AbilitiesPolicyDisable = "disable" // 置enabled = 0，保留priority、tag等字段
+	AbilitiesPolicyDelete  = "delete"  // 直接删除
+)
+
+type abilityKey struct {
+	Group string
+	Model string
+}
+
+// 按逗号拆分列表并去掉空项
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// 按渠道的分组、模型和变更后的状态计算abilities表需要的变更，与OneAPI保存渠道时的行为一致：
+// 每个分组与模型的组合各占一行，enabled取决于渠道是否启用，新行的priority取渠道的priority。
+// 不再需要的行按abilities_policy禁用或删除，已经符合预期的行不做变更。
+// 变更中记录行原来的内容，回滚时据此恢复
+func planAbilities(tx *gorm.DB, channelID int, models []string, status int, policy string, newAPI bool) ([]AbilityChange, error) {
+	var channel ChannelRecord
+	if err := tx.Select("group").Where("id = ?", channelID).Take(&channel).Error; err != nil {
+		return nil, err
+	}
+	enabled := status == ChannelStatusEnabled
+
+	desired := make(map[abilityKey]bool)
+	for _, g := range splitList(channel.Group) {
+		for _, model := range models {
+			desired[abilityKey{Group: g, Model: model}] = true
+		}
+	}
+
+	// 现有的行
+	existing, err := loadAbilityRows(tx, channelID, newAPI)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []AbilityChange
+	for key, row := range existing {
+		before := row
+		switch {
+		case desired[key]:
+			// 保留管理员设置的priority等字段，只更新启用状态
+			if row.Enabled != enabled {
+				change := abilityChange(key, enabled)
+				change.Before = &before
+				changes = append(changes, change)
+			}
+		case policy == AbilitiesPolicyDelete:
+			changes = append(changes, AbilityChange{Group: key.Group, Model: key.Model, Action: "delete", Before: &before})
+		case row.Enabled:
+			changes = append(changes, AbilityChange{Group: key.Group, Model: key.Model, Action: "disable", Before: &before})
+		}
+	}
+	for key := range desired {
+		if _, ok := existing[key]; !ok {
+			changes = append(changes, AbilityChange{Group: key.Group, Model: key.Model, Action: "insert"})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Group != changes[j].Group {
+			return changes[i].Group < changes[j].Group
+		}
+		return changes[i].Model < changes[j].Model
+	})
+	return changes, nil
+}
+
+// 读取渠道现有的abilities行
+func loadAbilityRows(tx *gorm.DB, channelID int, newAPI bool) (map[abilityKey]AbilityRow, error) {
+	rows := make(map[abilityKey]AbilityRow)
+	if newAPI {
+		var abilities []NewAPIAbilityRecord
+		if err := tx.Where("channel_id = ?", channelID).Find(&abilities).Error; err != nil {
+			return nil, err
+		}
+		for _, a := range abilities {
+			weight := a.Weight
+			rows[abilityKey{Group: a.Group, Model: a.Model}] = AbilityRow{Enabled: a.Enabled, Priority: a.Priority, Weight: &weight, Tag: a.Tag}
+		}
+		return rows, nil
+	}
+	var abilities []AbilityRecord
+	if err := tx.Where("channel_id = ?", channelID).Find(&abilities).Error; err != nil {
+		return nil, err
+	}
+	for _, a := range abilities {
+		rows[abilityKey{Group: a.Group, Model: a.Model}] = AbilityRow{Enabled: a.Enabled, Priority: a.Priority}
+	}
+	return rows, nil
+}
+
+// 将渠道的abilities行恢复为rows，rows以外的行删除，内容相同的行不做变更
+func restoreAbilityRows(db *gorm.DB, channelID int, rows map[abilityKey]AbilityRow, newAPI bool) error {
+	current, err := loadAbilityRows(db, channelID, newAPI)
+	if err != nil {
+		return err
+	}
+	var changes []AbilityChange
+	for key := range current {
+		if _, ok := rows[key]; !ok {
+			changes = append(changes, AbilityChange{Group: key.Group, Model: key.Model, Action: "delete"})
+		}
+	}
+	for key, row := range rows {
+		if old, ok := current[key]; ok && reflect.DeepEqual(old, row) {
+			continue
+		}
+		before := row
+		changes = append(changes, AbilityChange{Group: key.Group, Model: key.Model, Action: "restore", Before: &before})
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := applyAbilityChanges(tx, channelID, changes, ChannelStatusEnabled, newAPI); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func abilityChange(key abilityKey, enabled bool) AbilityChange {
+	action := "disable"
+	if enabled {
+		action = "enable"
+	}
+	return AbilityChange{Group: key.Group, Model: key.Model, Action: action}
+}
+
+// 在事务中执行planAbilities得到的变更，status为渠道变更后的状态。
+// newAPI为true时新行的tag和weight也取渠道的值；restore按Before恢复被删除的行
+func applyAbilityChanges(tx *gorm.DB, channelID int, changes []AbilityChange, status int, newAPI bool) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	var channel NewAPIChannelRecord
+	columns := []string{"priority"}
+	if newAPI {
+		columns = append(columns, "weight", "tag")
+	}
+	if err := tx.Select(columns).Where("id = ?", channelID).Take(&channel).Error; err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+		var err error
+		switch c.Action {
+		case "insert", "restore":
+			row := AbilityRecord{
+				Group:     c.Group,
+				Model:     c.Model,
+				ChannelID: channelID,
+				Enabled:   status == ChannelStatusEnabled,
+				Priority:  channel.Priority,
+			}
+			tag, weight := channel.Tag, channel.Weight
+			if c.Action == "restore" && c.Before != nil {
+				row.Enabled, row.Priority = c.Before.Enabled, c.Before.Priority
+				if c.Before.Weight != nil {
+					tag, weight = c.Before.Tag, c.Before.Weight
+				}
+				// 删除后可能又被插入过，以恢复的内容为准
+				if err = tx.Where(abilityWhere(channelID, c.Group, c.Model)).Delete(&AbilityRecord{}).Error; err != nil {
+					return err
+				}
+			}
+			if newAPI {
+				newRow := NewAPIAbilityRecord{AbilityRecord: row, Tag: tag}
+				if weight != nil {
+					newRow.Weight = *weight
+				}
+				err = tx.Create(&newRow).Error
+			} else {
+				err = tx.Create(&row).Error
+			}
+		case "enable", "disable":
+			err = tx.Model(&AbilityRecord{}).Where(abilityWhere(channelID, c.Group, c.Model)).Update("enabled", c.Action == "enable").Error
+		case "delete":
+			err = tx.Where(abilityWhere(channelID, c.Group, c.Model)).Delete(&AbilityRecord{}).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// 网关类型
+const (
+	BackendOneAPI = "oneapi"
+	BackendNewAPI = "newapi"
+	BackendOneHub = "onehub"
+	BackendVoAPI  = "voapi"
+	BackendREST   = "rest" // 其他兼容OneAPI管理接口的网关
+)
+
+// ChannelState 渠道当前的模型列表和状态
+type ChannelState struct {
+	Models []string
+	Status int
+}
+
+// Backend 对网关渠道数据的读写，不同网关的表结构和管理接口各不相同
+type Backend interface {
+	// ListChannels 列出所有渠道，BaseURL为渠道中保存的原始值
+	ListChannels() ([]Channel, error)
+	// ReadChannel 读取渠道当前的模型列表和状态
+	ReadChannel(channelID int) (ChannelState, error)
+	// PlanAbilities 计算abilities表需要的变更，由网关自行维护abilities时返回nil
+	PlanAbilities(channelID int, models []string, status int) ([]AbilityChange, error)
+	// Apply 写入渠道的模型列表、abilities变更和状态
+	Apply(plan ChannelPlan) error
+}
+
+// 按oneapi_type选择网关的读写方式，db为nil时完全通过管理接口读写
+func newBackend(config *Config, db *gorm.DB) (Backend, error) {
+	if db == nil {
+		switch config.OneAPIType {
+		case BackendOneAPI, BackendNewAPI, BackendOneHub, BackendVoAPI, BackendREST:
+			return &restBackend{cfg: config}, nil
+		default:
+			return nil, fmt.Errorf("未知的oneapi_type: %s", config.OneAPIType)
+		}
+	}
+
+	switch config.OneAPIType {
+	case BackendOneAPI:
+		return &sqlBackend{db: db, abilitiesPolicy: config.AbilitiesPolicy}, nil
+	case BackendNewAPI:
+		return &sqlBackend{db: db, abilitiesPolicy: config.AbilitiesPolicy, newAPI: true}, nil
+	case BackendOneHub, BackendVoAPI, BackendREST:
+		if config.BaseURL == "" || config.SystemToken == "" {
+			return nil, fmt.Errorf("oneapi_type为%s时需要配置base_url和system_token", config.OneAPIType)
+		}
+		return &restBackend{cfg: config, sql: &sqlBackend{db: db}}, nil
+	default:
+		return nil, fmt.Errorf("未知的oneapi_type: %s", config.OneAPIType)
+	}
+}
+
+// 创建调用网关管理接口的请求，使用system_token鉴权。
+// NewAPI的管理接口还要求通过New-Api-User请求头指明令牌所属的用户
+func newAdminRequest(cfg *Config, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, cfg.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.SystemToken)
+	if cfg.OneAPIType == BackendNewAPI || cfg.OneAPIType == BackendVoAPI {
+		req.Header.Set("New-Api-User", fmt.Sprintf("%d", cfg.AdminUserID))
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// 解析渠道的model_mapping字段
+func parseModelMapping(s string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	if s == "" {
+		return mapping, nil
+	}
+	if err := json.Unmarshal([]byte(s), &mapping); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
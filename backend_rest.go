@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 管理接口分页获取渠道时每页的数量，OneAPI会忽略该参数并使用自身的设置
+const restPageSize = 100
+
+// restBackend 通过网关的管理接口更新渠道，abilities由网关保存渠道时自行维护。
+// 连接了数据库时从数据库读取渠道，否则同样通过管理接口读取
+type restBackend struct {
+	cfg *Config
+	sql *sqlBackend
+}
+
+// restChannel 管理接口返回的渠道字段
+type restChannel struct {
+	ID           int     `json:"id"`
+	Type         int     `json:"type"`
+	Name         string  `json:"name"`
+	BaseURL      *string `json:"base_url"`
+	Key          string  `json:"key"`
+	Status       int     `json:"status"`
+	Models       string  `json:"models"`
+	ModelMapping *string `json:"model_mapping"`
+}
+
+type adminResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// 调用管理接口并检查success字段
+func callAdminAPI(cfg *Config, method, path string, payload interface{}) (json.RawMessage, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := newAdminRequest(cfg, method, path, body)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败：%v", err)
+	}
+
+	client := &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("状态码：%d", resp.StatusCode)
+	}
+	var response adminResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("解析响应失败：%v", err)
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("%s", response.Message)
+	}
+	return response.Data, nil
+}
+
+// 分页获取渠道，直到某一页没有新的渠道或达到总数
+func (b *restBackend) ListChannels() ([]Channel, error) {
+	if b.sql != nil {
+		return b.sql.ListChannels()
+	}
+
+	// OneHub的页码从1开始，OneAPI和旧版NewAPI从0开始，新版NewAPI会把0当作1
+	page := 0
+	if b.cfg.OneAPIType == BackendOneHub {
+		page = 1
+	}
+	seen := make(map[int]bool)
+	var channels []Channel
+	for {
+		path := fmt.Sprintf("/api/channel/?p=%d&page_size=%d", page, restPageSize)
+		if b.cfg.OneAPIType == BackendOneHub {
+			path = fmt.Sprintf("/api/channel/?page=%d&size=%d", page, restPageSize)
+		}
+		data, err := callAdminAPI(b.cfg, "GET", path, nil)
+		if err != nil {
+			return nil, fmt.Errorf("获取渠道列表失败：%v", err)
+		}
+		items, total, current, err := decodeChannelPage(data)
+		if err != nil {
+			return nil, fmt.Errorf("解析渠道列表失败：%v", err)
+		}
+
+		added := 0
+		for _, item := range items {
+			if seen[item.ID] {
+				continue
+			}
+			seen[item.ID] = true
+			added++
+			c := Channel{
+				ID:     item.ID,
+				Type:   item.Type,
+				Name:   item.Name,
+				Key:    item.Key,
+				Status: item.Status,
+			}
+			if item.BaseURL != nil {
+				c.BaseURL = *item.BaseURL
+			}
+			mapping := ""
+			if item.ModelMapping != nil {
+				mapping = *item.ModelMapping
+			}
+			if c.ModelMapping, err = parseModelMapping(mapping); err != nil {
+				return nil, err
+			}
+			channels = append(channels, c)
+		}
+		if added == 0 || (total > 0 && len(channels) >= total) {
+			break
+		}
+		// 以网关返回的页码为准
+		if current > 0 {
+			page = current
+		}
+		page++
+	}
+	return channels, nil
+}
+
+// 解析一页渠道。OneAPI和旧版NewAPI直接返回数组；新版NewAPI返回{items, total, page}，
+// OneHub返回{data, total_count, page}。没有总数时total为0
+func decodeChannelPage(data json.RawMessage) (items []restChannel, total int, page int, err error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || string(data) == "null" {
+		return nil, 0, 0, nil
+	}
+	if data[0] == '[' {
+		err = json.Unmarshal(data, &items)
+		return items, 0, 0, err
+	}
+
+	var result struct {
+		Items      []restChannel `json:"items"`
+		Data       []restChannel `json:"data"`
+		Total      int           `json:"total"`
+		TotalCount int           `json:"total_count"`
+		Page       int           `json:"page"`
+	}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, 0, 0, err
+	}
+	items = result.Items
+	if items == nil {
+		items = result.Data
+	}
+	total = result.Total
+	if total == 0 {
+		total = result.TotalCount
+	}
+	return items, total, result.Page, nil
+}
+
+func (b *restBackend) ReadChannel(channelID int) (ChannelState, error) {
+	if b.sql != nil {
+		return b.sql.ReadChannel(channelID)
+	}
+	data, err := callAdminAPI(b.cfg, "GET", fmt.Sprintf("/api/channel/%d", channelID), nil)
+	if err != nil {
+		return ChannelState{}, fmt.Errorf("获取渠道详情失败：%v", err)
+	}
+	var channel restChannel
+	if err := json.Unmarshal(data, &channel); err != nil {
+		return ChannelState{}, fmt.Errorf("解析渠道详情失败：%v", err)
+	}
+	return ChannelState{Models: splitList(channel.Models), Status: channel.Status}, nil
+}
+
+func (b *restBackend) PlanAbilities(channelID int, models []string, status int) ([]AbilityChange, error) {
+	return nil, nil
+}
+
+// 获取渠道详情的全部字段，用于修改后整体PUT回去
+func getChannelDetail(cfg *Config, channelID int) (map[string]interface{}, error) {
+	data, err := callAdminAPI(cfg, "GET", fmt.Sprintf("/api/channel/%d", channelID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("获取渠道详情失败：%v", err)
+	}
+
+	var channel map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // 避免大整数字段丢失精度
+	if err := decoder.Decode(&channel); err != nil {
+		return nil, fmt.Errorf("解析渠道详情失败：%v", err)
+	}
+	return channel, nil
+}
+
+// 先获取渠道详情，只修改模型和状态后整体PUT回去，
+// 各网关的其余字段（插件、代理、标签等）原样保留
+func (b *restBackend) Apply(plan ChannelPlan) error {
+	channel, err := getChannelDetail(b.cfg, plan.ChannelID)
+	if err != nil {
+		return err
+	}
+
+	// 更新模型和状态
+	channel["models"] = strings.Join(plan.NewModels, ",")
+	channel["status"] = plan.NewStatus
+
+	if _, err := callAdminAPI(b.cfg, "PUT", "/api/channel/", channel); err != nil {
+		return fmt.Errorf("更新渠道失败：%v", err)
+	}
+	return nil
+}
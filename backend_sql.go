@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// sqlBackend 直接读写OneAPI或NewAPI的数据库
+type sqlBackend struct {
+	db              *gorm.DB
+	abilitiesPolicy string
+	newAPI          bool // NewAPI的abilities表多出tag和weight字段
+}
+
+func (b *sqlBackend) ListChannels() ([]Channel, error) {
+	var records []ChannelRecord
+	err := b.db.Select("id", "type", "name", "base_url", "key", "status", "model_mapping").Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var channels []Channel
+	for _, record := range records {
+		c := Channel{
+			ID:     record.ID,
+			Type:   record.Type,
+			Name:   record.Name,
+			Key:    record.Key,
+			Status: record.Status,
+		}
+		if record.BaseURL != nil {
+			c.BaseURL = *record.BaseURL
+		}
+		mapping := ""
+		if record.ModelMapping != nil {
+			mapping = *record.ModelMapping
+		}
+		if c.ModelMapping, err = parseModelMapping(mapping); err != nil {
+			return nil, err
+		}
+		channels = append(channels, c)
+	}
+	return channels, nil
+}
+
+func (b *sqlBackend) ReadChannel(channelID int) (ChannelState, error) {
+	var record ChannelRecord
+	if err := b.db.Select("models", "status").Where("id = ?", channelID).Take(&record).Error; err != nil {
+		return ChannelState{}, err
+	}
+	return ChannelState{Models: splitList(record.Models), Status: record.Status}, nil
+}
+
+func (b *sqlBackend) PlanAbilities(channelID int, models []string, status int) ([]AbilityChange, error) {
+	return planAbilities(b.db, channelID, models, status, b.abilitiesPolicy, b.newAPI)
+}
+
+// 在同一个事务中更新channels和abilities表
+func (b *sqlBackend) Apply(plan ChannelPlan) error {
+	tx := b.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	// 更新channels表
+	updates := map[string]interface{}{"models": strings.Join(plan.NewModels, ",")}
+	if plan.statusChanged() {
+		updates["status"] = plan.NewStatus
+	}
+	if err := tx.Model(&ChannelRecord{}).Where("id = ?", plan.ChannelID).Updates(updates).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// 同步abilities表，补齐新模型的行
+	if err := applyAbilityChanges(tx, plan.ChannelID, plan.Abilities, plan.NewStatus, b.newAPI); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
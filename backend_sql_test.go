@@ -0,0 +1,257 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// 与OneAPI在SQLite上建出的表结构一致，只保留与监控相关及容易冲突的字段
+var oneAPISchema = []string{
+	"CREATE TABLE `channels` (`id` integer PRIMARY KEY AUTOINCREMENT, `type` integer DEFAULT 0, `key` text, `status` integer DEFAULT 1, `name` text, `weight` integer DEFAULT 0, `base_url` text DEFAULT '', `other` text, `models` text, `group` varchar(32) DEFAULT 'default', `model_mapping` varchar(1024) DEFAULT '', `priority` integer DEFAULT 0, `config` text)",
+	"CREATE TABLE `abilities` (`group` varchar(32), `model` varchar(255), `channel_id` integer, `enabled` numeric, `priority` integer DEFAULT 0, PRIMARY KEY (`group`,`model`,`channel_id`))",
+}
+
+// NewAPI的channels和abilities表多出tag、weight等字段
+var newAPISchema = []string{
+	"CREATE TABLE `channels` (`id` integer PRIMARY KEY AUTOINCREMENT, `type` integer DEFAULT 0, `key` text NOT NULL, `status` integer DEFAULT 1, `name` text, `weight` integer DEFAULT 0, `base_url` text DEFAULT '', `other` text, `models` text, `group` varchar(64) DEFAULT 'default', `model_mapping` text, `priority` integer DEFAULT 0, `auto_ban` integer DEFAULT 1, `tag` text, `setting` text)",
+	"CREATE TABLE `abilities` (`group` varchar(64), `model` varchar(255), `channel_id` integer, `enabled` numeric, `priority` integer DEFAULT 0, `weight` integer DEFAULT 0, `tag` text, PRIMARY KEY (`group`,`model`,`channel_id`))",
+}
+
+func newTestDB(t *testing.T, schema []string, statements ...string) *gorm.DB {
+	t.Helper()
+	return openTestDB(t, filepath.Join(t.TempDir(), "gateway.db"), schema, statements...)
+}
+
+func openTestDB(t *testing.T, dsn string, schema []string, statements ...string) *gorm.DB {
+	t.Helper()
+	db, err := NewDB(Config{DbType: "sqlite", DbDsn: dsn})
+	if err != nil {
+		t.Fatalf("打开SQLite失败: %v", err)
+	}
+	for _, sql := range append(schema, statements...) {
+		if err := db.Exec(sql).Error; err != nil {
+			t.Fatalf("执行 %s 失败: %v", sql, err)
+		}
+	}
+	return db
+}
+
+// 按cfg创建连接SQLite网关数据库的Target，同时作为进程的配置
+func newTestTarget(t *testing.T, cfg Config, schema []string, statements ...string) (*Target, *gorm.DB) {
+	t.Helper()
+	cfg.Name = "test"
+	cfg.DbType = "sqlite"
+	cfg.DbDsn = filepath.Join(t.TempDir(), "gateway.db")
+	db := openTestDB(t, cfg.DbDsn, schema, statements...)
+	cfg.setDefaults()
+	setConfig(&cfg)
+	target, err := newTarget(&cfg)
+	if err != nil {
+		t.Fatalf("newTarget: %v", err)
+	}
+	return target, db
+}
+
+type testAbility struct {
+	Group    string
+	Model    string
+	Enabled  bool
+	Priority int64
+	Weight   uint
+	Tag      string
+}
+
+func readAbilities(t *testing.T, db *gorm.DB, channelID int) map[string]testAbility {
+	t.Helper()
+	query := "SELECT `group`, `model`, `enabled`, COALESCE(`priority`, 0), COALESCE(`weight`, 0), COALESCE(`tag`, '') FROM abilities WHERE channel_id = ?"
+	if !db.Migrator().HasColumn("abilities", "weight") {
+		// OneAPI的abilities表没有weight和tag
+		query = "SELECT `group`, `model`, `enabled`, COALESCE(`priority`, 0), 0, '' FROM abilities WHERE channel_id = ?"
+	}
+	rows, err := db.Raw(query, channelID).Rows()
+	if err != nil {
+		t.Fatalf("读取abilities失败: %v", err)
+	}
+	defer rows.Close()
+	abilities := make(map[string]testAbility)
+	for rows.Next() {
+		var a testAbility
+		if err := rows.Scan(&a.Group, &a.Model, &a.Enabled, &a.Priority, &a.Weight, &a.Tag); err != nil {
+			t.Fatalf("读取abilities失败: %v", err)
+		}
+		abilities[a.Group+"/"+a.Model] = a
+	}
+	return abilities
+}
+
+func changeActions(changes []AbilityChange) []string {
+	var actions []string
+	for _, c := range changes {
+		actions = append(actions, c.Action+" "+c.Group+"/"+c.Model)
+	}
+	return actions
+}
+
+func TestSQLBackendOneAPI(t *testing.T) {
+	db := newTestDB(t, oneAPISchema,
+		"INSERT INTO channels (id, type, `key`, status, name, base_url, models, `group`, model_mapping, priority) VALUES (1, 1, 'sk-1', 1, 'openai', 'https://api.example.com', 'gpt-4o,gpt-4o-mini', 'default,vip', '{\"gpt-4o\":\"gpt-4o-2024-08-06\"}', 5)",
+		"INSERT INTO channels (id, type, `key`, status, name, models) VALUES (2, 14, 'sk-2', 3, 'claude', 'claude-3-5-sonnet')",
+		"INSERT INTO abilities VALUES ('default', 'gpt-4o', 1, 1, 20), ('vip', 'gpt-4o', 1, 1, 30), ('default', 'gpt-4o-mini', 1, 1, 5), ('vip', 'gpt-4o-mini', 1, 1, 5)",
+	)
+	b := &sqlBackend{db: db, abilitiesPolicy: AbilitiesPolicyDisable}
+
+	channels, err := b.ListChannels()
+	if err != nil {
+		t.Fatalf("ListChannels: %v", err)
+	}
+	if len(channels) != 2 {
+		t.Fatalf("ListChannels 返回 %d 个渠道，期望2个", len(channels))
+	}
+	if c := channels[0]; c.ID != 1 || c.Key != "sk-1" || c.BaseURL != "https://api.example.com" || c.ModelMapping["gpt-4o"] != "gpt-4o-2024-08-06" {
+		t.Errorf("ListChannels 返回的渠道不正确: %+v", c)
+	}
+
+	state, err := b.ReadChannel(2)
+	if err != nil {
+		t.Fatalf("ReadChannel: %v", err)
+	}
+	if !reflect.DeepEqual(state, ChannelState{Models: []string{"claude-3-5-sonnet"}, Status: ChannelStatusAutoDisabled}) {
+		t.Errorf("ReadChannel 返回 %+v", state)
+	}
+
+	// gpt-4o-mini被移除，新增o1
+	models := []string{"gpt-4o", "o1"}
+	changes, err := b.PlanAbilities(1, models, ChannelStatusEnabled)
+	if err != nil {
+		t.Fatalf("PlanAbilities: %v", err)
+	}
+	want := []string{"disable default/gpt-4o-mini", "insert default/o1", "disable vip/gpt-4o-mini", "insert vip/o1"}
+	if got := changeActions(changes); !reflect.DeepEqual(got, want) {
+		t.Fatalf("PlanAbilities 返回 %v，期望 %v", got, want)
+	}
+
+	plan := ChannelPlan{ChannelID: 1, NewModels: models, Abilities: changes, OldStatus: ChannelStatusEnabled, NewStatus: ChannelStatusEnabled}
+	if err := b.Apply(plan); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if state, _ := b.ReadChannel(1); strings.Join(state.Models, ",") != "gpt-4o,o1" {
+		t.Errorf("Apply 后渠道模型为 %v", state.Models)
+	}
+	abilities := readAbilities(t, db, 1)
+	if a := abilities["vip/gpt-4o"]; !a.Enabled || a.Priority != 30 {
+		t.Errorf("保留的行应保持原有priority: %+v", a)
+	}
+	if a := abilities["default/gpt-4o-mini"]; a.Enabled || a.Priority != 5 {
+		t.Errorf("移除的模型应被禁用且保留priority: %+v", a)
+	}
+	if a, ok := abilities["vip/o1"]; !ok || !a.Enabled || a.Priority != 5 {
+		t.Errorf("新模型应按渠道的priority插入: %+v", a)
+	}
+
+	// 已经符合预期时不产生变更
+	if changes, err := b.PlanAbilities(1, models, ChannelStatusEnabled); err != nil || len(changes) != 0 {
+		t.Errorf("重复计算应没有变更，得到 %v, %v", changeActions(changes), err)
+	}
+
+	// 渠道被禁用时所有行一起禁用
+	changes, err = b.PlanAbilities(1, models, ChannelStatusAutoDisabled)
+	if err != nil {
+		t.Fatalf("PlanAbilities: %v", err)
+	}
+	plan = ChannelPlan{ChannelID: 1, NewModels: models, Abilities: changes, OldStatus: ChannelStatusEnabled, NewStatus: ChannelStatusAutoDisabled}
+	if err := b.Apply(plan); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if state, _ := b.ReadChannel(1); state.Status != ChannelStatusAutoDisabled {
+		t.Errorf("Apply 后渠道状态为 %d", state.Status)
+	}
+	for key, a := range readAbilities(t, db, 1) {
+		if a.Enabled {
+			t.Errorf("渠道禁用后 %s 仍然启用", key)
+		}
+	}
+}
+
+func TestSQLBackendNewAPI(t *testing.T) {
+	db := newTestDB(t, newAPISchema,
+		"INSERT INTO channels (id, type, `key`, status, name, models, `group`, priority, weight, tag) VALUES (7, 1, 'sk-7', 1, 'openai', 'gpt-4o,gpt-4o-mini', 'default', 2, 9, 'team-a')",
+		"INSERT INTO abilities VALUES ('default', 'gpt-4o', 7, 1, 50, 3, 'custom'), ('default', 'gpt-4o-mini', 7, 1, 2, 9, 'team-a')",
+	)
+	b := &sqlBackend{db: db, abilitiesPolicy: AbilitiesPolicyDelete, newAPI: true}
+
+	channels, err := b.ListChannels()
+	if err != nil || len(channels) != 1 || channels[0].ID != 7 {
+		t.Fatalf("ListChannels 返回 %+v, %v", channels, err)
+	}
+
+	models := []string{"gpt-4o", "o1"}
+	changes, err := b.PlanAbilities(7, models, ChannelStatusEnabled)
+	if err != nil {
+		t.Fatalf("PlanAbilities: %v", err)
+	}
+	want := []string{"delete default/gpt-4o-mini", "insert default/o1"}
+	if got := changeActions(changes); !reflect.DeepEqual(got, want) {
+		t.Fatalf("PlanAbilities 返回 %v，期望 %v", got, want)
+	}
+	if before := changes[0].Before; before == nil || before.Priority == nil || *before.Priority != 2 || before.Weight == nil || *before.Weight != 9 {
+		t.Errorf("删除的行应记录原有内容: %+v", before)
+	}
+
+	plan := ChannelPlan{ChannelID: 7, NewModels: models, Abilities: changes, OldStatus: ChannelStatusEnabled, NewStatus: ChannelStatusEnabled}
+	if err := b.Apply(plan); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	abilities := readAbilities(t, db, 7)
+	if _, ok := abilities["default/gpt-4o-mini"]; ok {
+		t.Errorf("abilities_policy为delete时移除的模型应被删除")
+	}
+	if a := abilities["default/gpt-4o"]; a.Priority != 50 || a.Weight != 3 || a.Tag != "custom" {
+		t.Errorf("保留的行应保持原有字段: %+v", a)
+	}
+	if a := abilities["default/o1"]; !a.Enabled || a.Priority != 2 || a.Weight != 9 || a.Tag != "team-a" {
+		t.Errorf("新模型应取渠道的priority、weight和tag: %+v", a)
+	}
+
+	// 回滚时按记录的内容恢复被删除的行
+	revert := ChannelPlan{ChannelID: 7, NewModels: []string{"gpt-4o", "gpt-4o-mini"}, Abilities: revertAbilities(changes), OldStatus: ChannelStatusEnabled, NewStatus: ChannelStatusEnabled}
+	if err := b.Apply(revert); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	abilities = readAbilities(t, db, 7)
+	if _, ok := abilities["default/o1"]; ok {
+		t.Errorf("回滚后新增的行应被删除")
+	}
+	if a := abilities["default/gpt-4o-mini"]; !a.Enabled || a.Priority != 2 || a.Weight != 9 || a.Tag != "team-a" {
+		t.Errorf("回滚后应恢复被删除的行: %+v", a)
+	}
+}
+
+func TestResolveBackendRefusesWrites(t *testing.T) {
+	newAPI := newTestDB(t, newAPISchema)
+	broken := newTestDB(t, []string{"CREATE TABLE `channels` (`id` integer PRIMARY KEY, `name` text)"})
+
+	cases := []struct {
+		name    string
+		db      *gorm.DB
+		cfg     Config
+		dryRun  bool
+		backend string
+	}{
+		{"auto", newAPI, Config{OneAPIType: BackendAuto}, false, BackendNewAPI},
+		{"mismatch", newAPI, Config{OneAPIType: BackendOneAPI}, true, BackendOneAPI},
+		{"forced", newAPI, Config{OneAPIType: BackendOneAPI, ForceOneAPIType: true}, false, BackendOneAPI},
+		{"voapi", newAPI, Config{OneAPIType: BackendVoAPI}, false, BackendVoAPI},
+		{"missing columns", broken, Config{OneAPIType: BackendNewAPI, ForceOneAPIType: true}, true, BackendNewAPI},
+	}
+	for _, c := range cases {
+		cfg := c.cfg
+		resolveBackend(&cfg, c.db)
+		if cfg.DoNotModifyDb != c.dryRun || cfg.OneAPIType != c.backend {
+			t.Errorf("%s: do_not_modify_db为%v、oneapi_type为%s，期望%v、%s", c.name, cfg.DoNotModifyDb, cfg.OneAPIType, c.dryRun, c.backend)
+		}
+	}
+}
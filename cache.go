@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// 直接写入数据库后通知网关重新加载渠道缓存的方式
+const (
+	CacheReloadNone    = "none"    // 等待网关按SYNC_FREQUENCY自行同步
+	CacheReloadTouch   = "touch"   // 通过管理接口原样保存一个刚更新的渠道，适用于保存渠道时会刷新缓存的网关
+	CacheReloadRequest = "request" // 调用指定的管理接口
+)
+
+type CacheReloadConfig struct {
+	Mode   string `json:"mode" yaml:"mode"`
+	Method string `json:"method" yaml:"method"` // request方式的HTTP方法，默认POST
+	Path   string `json:"path" yaml:"path"`     // request方式的接口路径，如/api/channel/reload
+}
+
+// 记录一次直接写入数据库的渠道，由flushCacheReload统一通知网关
+func (t *Target) markCacheDirty(channelID int) {
+	if _, ok := t.Backend().(*sqlBackend); !ok {
+		// 通过管理接口写入时由网关自行更新缓存
+		return
+	}
+	t.cacheMu.Lock()
+	t.cacheChannel = channelID
+	t.cacheMu.Unlock()
+}
+
+// 有待刷新的写入时按cache_reload通知网关，每批写入只通知一次
+func (t *Target) flushCacheReload() {
+	t.cacheMu.Lock()
+	channelID := t.cacheChannel
+	t.cacheChannel = 0
+	t.cacheMu.Unlock()
+	if channelID == 0 || t.Config().CacheReload.Mode == CacheReloadNone {
+		return
+	}
+
+	startTime := time.Now()
+	var err error
+	if t.Config().CacheReload.Mode == CacheReloadTouch {
+		err = t.touchChannel(channelID)
+	} else {
+		err = t.requestCacheReload()
+	}
+	dbOperationDuration.WithLabelValues(t.Name, "cache_reload").Observe(time.Since(startTime).Seconds())
+	if err != nil {
+		dbOperationTotal.WithLabelValues(t.Name, "cache_reload", "error").Inc()
+		log.Printf("\033[31m通知网关刷新渠道缓存失败：%v\033[0m\n", err)
+		return
+	}
+	dbOperationTotal.WithLabelValues(t.Name, "cache_reload", "success").Inc()
+	log.Println("已通知网关刷新渠道缓存")
+}
+
+// 获取渠道详情后原样PUT回去，不改变任何字段。
+// OneAPI和NewAPI保存渠道时会删除该渠道的abilities行并按渠道重新生成，
+// 被软禁用的行和单独设置的priority会丢失，因此PUT之后将abilities行恢复为保存前的内容。
+// 网关在处理PUT时已经刷新了缓存，恢复的内容要等到下一次SYNC_FREQUENCY同步后才进入缓存
+func (t *Target) touchChannel(channelID int) error {
+	b, ok := t.Backend().(*sqlBackend)
+	if !ok {
+		return fmt.Errorf("只有直接读写数据库时需要刷新缓存")
+	}
+	rows, err := loadAbilityRows(b.db, channelID, b.newAPI)
+	if err != nil {
+		return fmt.Errorf("读取abilities失败：%v", err)
+	}
+
+	channel, err := getChannelDetail(t.Config(), channelID)
+	if err != nil {
+		return err
+	}
+	if _, err := callAdminAPI(t.Config(), "PUT", "/api/channel/", channel); err != nil {
+		return fmt.Errorf("更新渠道失败：%v", err)
+	}
+
+	if err := restoreAbilityRows(b.db, channelID, rows, b.newAPI); err != nil {
+		return fmt.Errorf("恢复渠道 %d 的abilities失败：%v", channelID, err)
+	}
+	return nil
+}
+
+// 调用配置的刷新接口，只检查状态码
+func (t *Target) requestCacheReload() error {
+	cfg := t.Config()
+	req, err := newAdminRequest(cfg, cfg.CacheReload.Method, cfg.CacheReload.Path, nil)
+	if err != nil {
+		return fmt.Errorf("创建请求失败：%v", err)
+	}
+	client := &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("状态码：%d", resp.StatusCode)
+	}
+	return nil
+}
+
+func validateCacheReload(reload CacheReloadConfig, config *Config) error {
+	switch reload.Mode {
+	case CacheReloadNone:
+		return nil
+	case CacheReloadTouch, CacheReloadRequest:
+	default:
+		return fmt.Errorf("未知的cache_reload方式: %s", reload.Mode)
+	}
+	if config.BaseURL == "" || config.SystemToken == "" {
+		return fmt.Errorf("cache_reload为%s时需要配置base_url和system_token", reload.Mode)
+	}
+	if reload.Mode == CacheReloadRequest && reload.Path == "" {
+		return fmt.Errorf("cache_reload为request时需要配置path")
+	}
+	return nil
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 由build.sh通过-ldflags注入
+var (
+	Version   = "dev"
+	CommitID  = "unknown"
+	BuildTime = "unknown"
+)
+
+// 退出码
+const (
+	exitOK        = 0
+	exitUnhealthy = 1 // 存在不可用的渠道或模型，或周期被outage_guard拦截
+	exitError     = 2 // 参数、配置或连接错误
+)
+
+// 所有子命令共用的参数，可以放在子命令之前或之后
+type cliOptions struct {
+	configPath string
+	json       bool
+}
+
+type cliCommand struct {
+	name  string
+	usage string
+	run   func(opts *cliOptions, args []string) int
+}
+
+var cliCommands []cliCommand
+
+func init() {
+	cliCommands = []cliCommand{
+		{"run", "按time_period持续检测（默认）", commandRun},
+		{"once", "对所有网关执行一个检测周期后退出", commandOnce},
+		{"test", "测试单个渠道或渠道上的一个模型，不写入网关：test --channel 12 [--model gpt-4o]", commandTest},
+		{"list-channels", "列出需要监控的渠道", commandListChannels},
+		{"plan", "执行一个检测周期但不写入网关，输出变更计划", commandPlan},
+		{"validate-config", "检查配置文件", commandValidateConfig},
+		{"history", "查看变更历史：history [--channel 12] [--cycle ID] [--limit 20]", commandHistory},
+		{"rollback", "回滚变更：rollback --record ID 或 rollback --cycle CYCLE_ID", commandRollback},
+		{"version", "显示版本信息", commandVersion},
+	}
+}
+
+func runCLI(args []string) int {
+	opts := &cliOptions{}
+	fs := newFlagSet("ChannelMonitor", opts)
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+	args = fs.Args()
+
+	name := "run"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	for _, c := range cliCommands {
+		if c.name == name {
+			return c.run(opts, args)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "未知的子命令：%s\n", name)
+	printUsage()
+	return exitError
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "用法：ChannelMonitor [--config 路径] [--json] <子命令> [参数]\n\n子命令：\n")
+	for _, c := range cliCommands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\n退出码：0 正常，1 存在不可用的渠道或模型，2 参数、配置或连接错误\n")
+}
+
+func newFlagSet(name string, opts *cliOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opts.configPath, "config", opts.configPath, "配置文件路径，默认使用环境变量CHANNELMONITOR_CONFIG，未设置时在当前目录查找config.yaml、config.yml或config.json")
+	fs.BoolVar(&opts.json, "json", opts.json, "以JSON格式输出")
+	if name == "ChannelMonitor" {
+		fs.Usage = printUsage
+	}
+	return fs
+}
+
+func flagExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	return exitError
+}
+
+// 输出错误并返回exitError
+func cliError(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "\033[31m"+format+"\033[0m\n", args...)
+	return exitError
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// 加载配置并连接所有网关
+func setup(opts *cliOptions) error {
+	config, err := loadConfig(opts.configPath)
+	if err != nil {
+		return fmt.Errorf("加载配置失败：%v", err)
+	}
+	setConfig(config)
+
+	if err := initResultStore(config.ResultStore); err != nil {
+		return fmt.Errorf("初始化结果存储失败：%v", err)
+	}
+
+	for _, cfg := range config.targets {
+		// 解析时间周期
+		if _, err := time.ParseDuration(cfg.TimePeriod); err != nil {
+			return fmt.Errorf("网关 %s 解析时间周期失败：%v", cfg.Name, err)
+		}
+		t, err := newTarget(cfg)
+		if err != nil {
+			return fmt.Errorf("网关 %s %v", cfg.Name, err)
+		}
+		targets = append(targets, t)
+	}
+	return nil
+}
+
+// name为空时返回所有网关
+func selectTargets(name string) ([]*Target, error) {
+	if name == "" {
+		return targets, nil
+	}
+	t, err := findTarget(name)
+	if err != nil {
+		return nil, err
+	}
+	return []*Target{t}, nil
+}
+
+// 只检测和演练的命令不发送通知，替换为关闭通知的副本，不修改已发布的配置
+func disableNotifications() {
+	cfg := *currentConfig()
+	cfg.Notification.SMTP.Enabled = false
+	cfg.Notification.Webhook.Enabled = false
+	setConfig(&cfg)
+}
+
+func commandRun(opts *cliOptions, args []string) int {
+	fs := newFlagSet("run", opts)
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+	if err := setup(opts); err != nil {
+		return cliError("%v", err)
+	}
+
+	// 启动Metrics服务器
+	config := currentConfig()
+	if config.metricsEnabled() {
+		go startMetricsServer()
+	} else {
+		log.Println("metrics_enabled为false，不启动Metrics服务器")
+	}
+	if config.StatusPage.Enabled {
+		go startStatusPageServer()
+	}
+	if config.PushGateway.Enabled {
+		startPushGatewayPusher(config.PushGateway)
+	}
+	// 配置文件修改或收到SIGHUP时重新加载配置
+	go watchConfigFile()
+	go handleReloadSignal()
+
+	// 各网关按自己的周期独立检测
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t *Target) {
+			defer wg.Done()
+			t.run()
+		}(t)
+	}
+	wg.Wait()
+	return exitOK
+}
+
+// 各网关同时执行一个检测周期，按网关顺序返回结果
+func runCycles(list []*Target) []*CycleReport {
+	reports := make([]*CycleReport, len(list))
+	var wg sync.WaitGroup
+	for i, t := range list {
+		wg.Add(1)
+		go func(i int, t *Target) {
+			defer wg.Done()
+			reports[i] = t.runCycle()
+		}(i, t)
+	}
+	wg.Wait()
+	return reports
+}
+
+// 所有渠道都有可用模型且未被拦截时视为健康
+func (r *CycleReport) healthy() bool {
+	if r.Error != "" || r.Blocked {
+		return false
+	}
+	for _, c := range r.Channels {
+		if c.Skipped || len(c.Available) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func reportsExitCode(reports []*CycleReport) int {
+	for _, r := range reports {
+		if r.Error != "" {
+			return exitError
+		}
+	}
+	for _, r := range reports {
+		if !r.healthy() {
+			return exitUnhealthy
+		}
+	}
+	return exitOK
+}
+
+func formatReport(r *CycleReport) string {
+	var b strings.Builder
+	if r.Error != "" {
+		fmt.Fprintf(&b, "网关 %s 检测失败：%s\n", r.Target, r.Error)
+		return b.String()
+	}
+	available := 0
+	for _, c := range r.Channels {
+		if len(c.Available) > 0 {
+			available++
+		}
+	}
+	fmt.Fprintf(&b, "网关 %s：%d 个渠道，%d 个有可用模型\n", r.Target, len(r.Channels), available)
+	if r.Blocked {
+		b.WriteString("  疑似监控端故障，变更未写入\n")
+	}
+	for _, c := range r.Channels {
+		if c.Skipped {
+			fmt.Fprintf(&b, "  渠道 %s(ID:%d) 未能获取模型列表，已跳过\n", c.Name, c.ID)
+			continue
+		}
+		fmt.Fprintf(&b, "  渠道 %s(ID:%d) 可用 %d/%d：%s\n", c.Name, c.ID, len(c.Available), c.Tested, strings.Join(c.Available, ","))
+		failed := make([]string, 0, len(c.Failures))
+		for model := range c.Failures {
+			failed = append(failed, model)
+		}
+		sort.Strings(failed)
+		for _, model := range failed {
+			fmt.Fprintf(&b, "    - %s (%s)\n", model, c.Failures[model])
+		}
+	}
+	return b.String()
+}
+
+func commandOnce(opts *cliOptions, args []string) int {
+	fs := newFlagSet("once", opts)
+	targetName := fs.String("target", "", "只检测该网关，默认检测所有网关")
+	dryRun := fs.Bool("dry-run", false, "只输出变更计划，不写入网关、不发送通知")
+	reportFile := fs.String("report", "", "将JSON报告写入该文件")
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+	if err := setup(opts); err != nil {
+		return cliError("%v", err)
+	}
+	list, err := selectTargets(*targetName)
+	if err != nil {
+		return cliError("%v", err)
+	}
+	if *dryRun {
+		disableNotifications()
+		for _, t := range list {
+			t.forceDryRun()
+		}
+	}
+
+	start := time.Now()
+	report := newOnceReport(runCycles(list), currentConfig().RunOnce, start, *dryRun)
+	if opts.json {
+		printJSON(report)
+	} else {
+		for _, r := range report.Targets {
+			fmt.Print(formatReport(r))
+		}
+		for _, problem := range report.Problems {
+			fmt.Println(problem)
+		}
+	}
+	if *reportFile != "" {
+		if err := writeOnceReport(report, *reportFile); err != nil {
+			log.Printf("\033[31m写入报告 %s 失败：%v\033[0m\n", *reportFile, err)
+		}
+	}
+
+	// 进程即将退出，推送一次本次检测的指标
+	if err := pushMetricsToPushGateway(currentConfig().PushGateway); err != nil {
+		log.Printf("\033[31m推送指标到PushGateway失败：%v\033[0m\n", err)
+	}
+	return report.ExitCode
+}
+
+func commandTest(opts *cliOptions, args []string) int {
+	fs := newFlagSet("test", opts)
+	targetName := fs.String("target", "", "网关名称，只有一个网关时可以省略")
+	channelID := fs.Int("channel", 0, "渠道ID")
+	model := fs.String("model", "", "只测试该模型，使用网关对外的模型名")
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+	if *channelID <= 0 {
+		return cliError("需要指定 --channel")
+	}
+	if err := setup(opts); err != nil {
+		return cliError("%v", err)
+	}
+	disableNotifications()
+	t, err := findTarget(*targetName)
+	if err != nil {
+		return cliError("%v", err)
+	}
+
+	if *model != "" {
+		report, err := t.testChannelModel(*channelID, *model)
+		if err != nil {
+			return cliError("%v", err)
+		}
+		if opts.json {
+			printJSON(report)
+		} else if report.Success {
+			fmt.Printf("渠道 %d 的模型 %s 测试成功，耗时 %.2fs\n", report.ChannelID, report.Model, report.Latency)
+		} else {
+			fmt.Printf("渠道 %d 的模型 %s 测试失败（%s），状态码：%d，响应：%s\n", report.ChannelID, report.Model, report.ErrorClass, report.StatusCode, report.Message)
+		}
+		if !report.Success {
+			return exitUnhealthy
+		}
+		return exitOK
+	}
+
+	channel, err := t.findChannel(*channelID)
+	if err != nil {
+		return cliError("%v", err)
+	}
+	start := time.Now()
+	report := t.newCycleReport(start, t.testChannels([]Channel{channel}, false))
+	report.Plan = nil
+	if opts.json {
+		printJSON(report)
+	} else {
+		fmt.Print(formatReport(report))
+	}
+	return reportsExitCode([]*CycleReport{report})
+}
+
+// channelListItem 列出渠道时的输出，不包含密钥
+type channelListItem struct {
+	Target  string `json:"target"`
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Type    int    `json:"type"`
+	Status  int    `json:"status"`
+	BaseURL string `json:"base_url"`
+}
+
+func commandListChannels(opts *cliOptions, args []string) int {
+	fs := newFlagSet("list-channels", opts)
+	targetName := fs.String("target", "", "只列出该网关的渠道，默认列出所有网关")
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+	if err := setup(opts); err != nil {
+		return cliError("%v", err)
+	}
+	list, err := selectTargets(*targetName)
+	if err != nil {
+		return cliError("%v", err)
+	}
+
+	items := []channelListItem{}
+	for _, t := range list {
+		channels, err := t.fetchChannels()
+		if err != nil {
+			return cliError("网关 %s 获取渠道失败：%v", t.Name, err)
+		}
+		for _, c := range channels {
+			items = append(items, channelListItem{Target: t.Name, ID: c.ID, Name: c.Name, Type: c.Type, Status: c.Status, BaseURL: c.BaseURL})
+		}
+	}
+	if opts.json {
+		printJSON(items)
+		return exitOK
+	}
+	for _, item := range items {
+		fmt.Printf("%s\t%d\t%s\ttype=%d\tstatus=%d\t%s\n", item.Target, item.ID, item.Name, item.Type, item.Status, item.BaseURL)
+	}
+	return exitOK
+}
+
+func commandPlan(opts *cliOptions, args []string) int {
+	fs := newFlagSet("plan", opts)
+	targetName := fs.String("target", "", "只检测该网关，默认检测所有网关")
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+	if err := setup(opts); err != nil {
+		return cliError("%v", err)
+	}
+	disableNotifications()
+	list, err := selectTargets(*targetName)
+	if err != nil {
+		return cliError("%v", err)
+	}
+	for _, t := range list {
+		t.forceDryRun()
+	}
+
+	reports := runCycles(list)
+	if opts.json {
+		plans := []*CyclePlan{}
+		for _, r := range reports {
+			if r.Plan != nil {
+				plans = append(plans, r.Plan)
+			}
+		}
+		printJSON(plans)
+	} else {
+		for _, r := range reports {
+			if r.Error != "" || r.Plan == nil {
+				fmt.Print(formatReport(r))
+				continue
+			}
+			fmt.Printf("网关 %s：\n%s", r.Target, formatPlan(r.Plan))
+		}
+	}
+	return reportsExitCode(reports)
+}
+
+// validateResult validate-config的输出
+type validateResult struct {
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors"`
+	Warnings []string `json:"warnings"` // 不影响运行但可能有误的配置
+	Targets  []string `json:"targets"`
+}
+
+func commandValidateConfig(opts *cliOptions, args []string) int {
+	fs := newFlagSet("validate-config", opts)
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+
+	result := validateResult{Errors: []string{}, Warnings: []string{}, Targets: []string{}}
+	cfg, err := loadConfig(opts.configPath)
+	var cfgErr *ConfigError
+	if errors.As(err, &cfgErr) {
+		result.Errors = append(result.Errors, cfgErr.Errors...)
+		result.Warnings = append(result.Warnings, cfgErr.Warnings...)
+	} else if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+	} else {
+		result.Warnings = append(result.Warnings, cfg.warnings...)
+		for _, target := range cfg.targets {
+			result.Targets = append(result.Targets, target.Name)
+		}
+	}
+	result.Valid = len(result.Errors) == 0
+
+	if opts.json {
+		printJSON(result)
+	} else {
+		for _, e := range result.Errors {
+			fmt.Println("错误: " + e)
+		}
+		for _, w := range result.Warnings {
+			fmt.Println("警告: " + w)
+		}
+		if result.Valid {
+			fmt.Printf("配置有效，网关：%s\n", strings.Join(result.Targets, ", "))
+		}
+	}
+	if !result.Valid {
+		return exitError
+	}
+	return exitOK
+}
+
+func commandHistory(opts *cliOptions, args []string) int {
+	fs := newFlagSet("history", opts)
+	targetName := fs.String("target", "", "网关名称，只有一个网关时可以省略")
+	channelID := fs.Int("channel", 0, "只显示该渠道的记录")
+	cycleID := fs.String("cycle", "", "只显示该周期的记录")
+	limit := fs.Int("limit", 20, "最多显示的记录数")
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+	if err := setup(opts); err != nil {
+		return cliError("%v", err)
+	}
+	t, err := findTarget(*targetName)
+	if err != nil {
+		return cliError("%v", err)
+	}
+	records, err := t.listHistory(*channelID, *cycleID, *limit)
+	if err != nil {
+		return cliError("%v", err)
+	}
+
+	if opts.json {
+		if records == nil {
+			records = []HistoryRecord{}
+		}
+		printJSON(records)
+		return exitOK
+	}
+	for _, r := range records {
+		fmt.Printf("#%d %s 周期 %s 渠道 %s(ID:%d)\n", r.ID, r.CreatedAt.Local().Format("2006-01-02 15:04:05"), r.CycleID, r.ChannelName, r.ChannelID)
+		fmt.Printf("  模型：%s -> %s\n", r.OldModels, r.NewModels)
+		if r.OldStatus != r.NewStatus {
+			fmt.Printf("  状态：%d -> %d\n", r.OldStatus, r.NewStatus)
+		}
+	}
+	return exitOK
+}
+
+// 命令行回滚：rollback [--target NAME] --record ID 或 rollback [--target NAME] --cycle CYCLE_ID
+func commandRollback(opts *cliOptions, args []string) int {
+	fs := newFlagSet("rollback", opts)
+	targetName := fs.String("target", "", "网关名称，只有一个网关时可以省略")
+	recordID := fs.Uint("record", 0, "回滚到该条变更记录之前的状态")
+	cycleID := fs.String("cycle", "", "回滚该周期内的所有渠道变更")
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+	if *recordID == 0 && *cycleID == "" {
+		return cliError("需要指定 --record 或 --cycle")
+	}
+	if err := setup(opts); err != nil {
+		return cliError("%v", err)
+	}
+	t, err := findTarget(*targetName)
+	if err != nil {
+		return cliError("%v", err)
+	}
+	plans, err := t.rollback(*recordID, *cycleID)
+	if err != nil {
+		return cliError("回滚失败：%v", err)
+	}
+
+	if opts.json {
+		if plans == nil {
+			plans = []ChannelPlan{}
+		}
+		printJSON(plans)
+		return exitOK
+	}
+	for _, plan := range plans {
+		fmt.Printf("渠道 %s(ID:%d): %s\n", plan.ChannelName, plan.ChannelID, strings.Join(plan.NewModels, ","))
+	}
+	return exitOK
+}
+
+func commandVersion(opts *cliOptions, args []string) int {
+	fs := newFlagSet("version", opts)
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+	if opts.json {
+		printJSON(map[string]string{"version": Version, "commit_id": CommitID, "build_time": BuildTime})
+		return exitOK
+	}
+	fmt.Printf("ChannelMonitor %s (commit %s, built %s)\n", Version, CommitID, BuildTime)
+	return exitOK
+}
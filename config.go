@@ -4,45 +4,66 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"gopkg.in/yaml.v3"
 )
+
 type PushGatewayConfig struct {
-    Enabled  bool   `json:"enabled" yaml:"enabled"`
-    URL      string `json:"url" yaml:"url"`
-    Job      string `json:"job" yaml:"job"`
-    Instance string `json:"instance" yaml:"instance"`
-    Interval string `json:"interval" yaml:"interval"`
+	Enabled  bool   `json:"enabled" yaml:"enabled"`
+	URL      string `json:"url" yaml:"url"`
+	Job      string `json:"job" yaml:"job"`
+	Instance string `json:"instance" yaml:"instance"`
+	Interval string `json:"interval" yaml:"interval"`
 }
 
 type Config struct {
-	OneAPIType        string   `json:"oneapi_type" yaml:"oneapi_type"`
-	ExcludeChannel    []int    `json:"exclude_channel" yaml:"exclude_channel"`
-	ExcludeModel      []string `json:"exclude_model" yaml:"exclude_model"`
-	Models            []string `json:"models" yaml:"models"`
-	ForceModels       bool     `json:"force_models" yaml:"force_models"`
-	ForceInsideModels bool     `json:"force_inside_models" yaml:"force_inside_models"`
-	TimePeriod        string   `json:"time_period" yaml:"time_period"`
-	MaxConcurrent     int      `json:"max_concurrent" yaml:"max_concurrent"`
-	RPS               int      `json:"rps" yaml:"rps"`
-	Timeout           int      `json:"timeout" yaml:"timeout"`
-	DbType            string   `json:"db_type" yaml:"db_type"`
-	DbDsn             string   `json:"db_dsn" yaml:"db_dsn"`
-	DoNotModifyDb     bool     `json:"do_not_modify_db" yaml:"do_not_modify_db"`
-	BaseURL           string   `json:"base_url" yaml:"base_url"`
-	SystemToken       string   `json:"system_token" yaml:"system_token"`
-	UptimeKuma        struct {
+	Name                   string                   `json:"name" yaml:"name"`
+	Targets                []map[string]interface{} `json:"targets" yaml:"targets"`
+	OneAPIType             string                   `json:"oneapi_type" yaml:"oneapi_type"`
+	ForceOneAPIType        bool                     `json:"force_oneapi_type" yaml:"force_oneapi_type"` // 明确配置的oneapi_type与检测结果不一致时仍然写入
+	ExcludeChannel         []int                    `json:"exclude_channel" yaml:"exclude_channel"`
+	ExcludeModel           []string                 `json:"exclude_model" yaml:"exclude_model"`
+	Models                 []string                 `json:"models" yaml:"models"`
+	ForceModels            bool                     `json:"force_models" yaml:"force_models"`
+	ForceInsideModels      bool                     `json:"force_inside_models" yaml:"force_inside_models"`
+	ProtectedModels        []string                 `json:"protected_models" yaml:"protected_models"`
+	ChannelProtectedModels map[string][]string      `json:"channel_protected_models" yaml:"channel_protected_models"`
+	TimePeriod             string                   `json:"time_period" yaml:"time_period"`
+	MaxConcurrent          int                      `json:"max_concurrent" yaml:"max_concurrent"`
+	RPS                    int                      `json:"rps" yaml:"rps"`
+	Timeout                int                      `json:"timeout" yaml:"timeout"`
+	DbType                 string                   `json:"db_type" yaml:"db_type"`
+	DbDsn                  string                   `json:"db_dsn" yaml:"db_dsn"`
+	DoNotModifyDb          bool                     `json:"do_not_modify_db" yaml:"do_not_modify_db"`
+	PlanFile               string                   `json:"plan_file" yaml:"plan_file"`
+	History                HistoryConfig            `json:"history" yaml:"history"`
+	OutageGuard            OutageGuardConfig        `json:"outage_guard" yaml:"outage_guard"`
+	ControlToken           string                   `json:"control_token" yaml:"control_token"`
+	BaseURL                string                   `json:"base_url" yaml:"base_url"`
+	SystemToken            string                   `json:"system_token" yaml:"system_token"`
+	AdminUserID            int                      `json:"admin_user_id" yaml:"admin_user_id"`
+	Probe                  ProbeConfig              `json:"probe" yaml:"probe"`
+	RoutingAdvisor         RoutingAdvisorConfig     `json:"routing_advisor" yaml:"routing_advisor"`
+	AbilitiesPolicy        string                   `json:"abilities_policy" yaml:"abilities_policy"`
+	EmptyPolicy            string                   `json:"empty_policy" yaml:"empty_policy"`
+	CacheReload            CacheReloadConfig        `json:"cache_reload" yaml:"cache_reload"`
+	ResultStore            ResultStoreConfig        `json:"result_store" yaml:"result_store"`
+	StatusPage             StatusPageConfig         `json:"status_page" yaml:"status_page"`
+	RunOnce                RunOnceConfig            `json:"run_once" yaml:"run_once"`
+	ConfigWatchInterval    string                   `json:"config_watch_interval" yaml:"config_watch_interval"` // 检查配置文件是否修改的间隔，默认10s，0为不检查
+	UptimeKuma             struct {
 		Status     string            `json:"status" yaml:"status"`
 		ModelURL   map[string]string `json:"model_url" yaml:"model_url"`
 		ChannelURL map[string]string `json:"channel_url" yaml:"channel_url"`
 	} `json:"uptime-kuma" yaml:"uptime-kuma"`
-	MetricsPort       string            `json:"metrics_port"`
-    MetricsEnabled    bool 		  `json:"metrics_enabled"`
+	MetricsPort    string            `json:"metrics_port" yaml:"metrics_port"`
+	MetricsEnabled *bool             `json:"metrics_enabled" yaml:"metrics_enabled"` // 是否启动HTTP服务，默认true
 	PushGateway    PushGatewayConfig `json:"push_gateway" yaml:"push_gateway"`
-	Notification struct {
+	Notification   struct {
 		SMTP struct {
 			Enabled  bool   `json:"enabled" yaml:"enabled"`
 			Host     string `json:"host" yaml:"host"`
@@ -62,11 +83,33 @@ type Config struct {
 			Secret string `json:"secret" yaml:"secret"`
 		} `json:"webhook" yaml:"webhook"`
 	} `json:"notification" yaml:"notification"`
+
+	targets  []*Config // 展开后每个网关的配置
+	path     string    // 读取的配置文件
+	warnings []string  // 不影响运行但可能有误的配置
+}
+
+// 是否启动Metrics服务器，未配置时启动
+func (c *Config) metricsEnabled() bool {
+	return c.MetricsEnabled == nil || *c.MetricsEnabled
 }
 
-func loadConfig() (*Config, error) {
+// 返回渠道受保护的模型，包括全局和该渠道单独设置的
+func (c *Config) protectedModels(channelID int) []string {
+	models := append([]string{}, c.ProtectedModels...)
+	return append(models, c.ChannelProtectedModels[fmt.Sprintf("%d", channelID)]...)
+}
+
+// path为空时使用CHANNELMONITOR_CONFIG，仍为空时在当前目录查找配置文件
+func loadConfig(path string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv(envConfigPath)
+	}
 	// 尝试加载不同格式的配置文件
 	possibleConfigs := []string{"config.yaml", "config.yml", "config.json"}
+	if path != "" {
+		possibleConfigs = []string{path}
+	}
 
 	var configFile string
 	var configData []byte
@@ -82,28 +125,143 @@ func loadConfig() (*Config, error) {
 		}
 	}
 
-	if configFile == "" {
+	if configFile == "" && path != "" {
+		return nil, fmt.Errorf("找不到配置文件 %s", path)
+	}
+	if configFile == "" && !hasEnvOverrides() {
 		return nil, fmt.Errorf("找不到配置文件，请创建 config.yaml、config.yml 或 config.json")
 	}
 
 	var config Config
 	ext := strings.ToLower(filepath.Ext(configFile))
+	isYAML := ext == ".yaml" || ext == ".yml"
+
+	// 收集所有问题后一起返回。先检查未知的配置项，字段类型不符时继续检查其余配置
+	problems := &ConfigError{}
+	if configFile == "" {
+		log.Println("未找到配置文件，只使用环境变量中的配置")
+	} else {
+		problems.Errors = append(problems.Errors, checkUnknownFields(configData, isYAML)...)
+		format := "JSON"
+		var err error
+		if isYAML {
+			format = "YAML"
+			err = yaml.Unmarshal(configData, &config)
+		} else {
+			err = json.Unmarshal(configData, &config)
+		}
+		if err != nil {
+			if !isTypeError(err) {
+				return nil, fmt.Errorf("解析%s配置文件失败: %v", format, err)
+			}
+			problems.errorf("解析%s配置文件失败: %v", format, err)
+		}
+		log.Printf("使用%s格式配置文件: %s\n", format, configFile)
+	}
+	problems.Warnings = append(problems.Warnings, unknownEnvOverrides()...)
+
+	// 先解析配置文件中的${file:...}、${env:...}引用，再用环境变量覆盖
+	config.resolveSecrets(problems)
+	applyEnvOverrides(&config, envPrefix, problems)
+
+	// 整个进程共用的配置
+	if config.StatusPage.Title == "" {
+		config.StatusPage.Title = "服务状态"
+	}
+	if config.StatusPage.Listen == "" {
+		config.StatusPage.Listen = ":2113"
+	}
+	if config.ConfigWatchInterval == "" {
+		config.ConfigWatchInterval = "10s"
+	}
+	config.validateProcess(problems)
+	config.path = configFile
+
+	// 配置了多个网关时，每个网关的配置为顶层配置加上该网关中设置的字段
+	if len(config.Targets) == 0 {
+		if config.Name == "" {
+			config.Name = "default"
+		}
+		config.setDefaults()
+		config.validate(problems, "")
+		config.targets = []*Config{&config}
+	}
+
+	names := make(map[string]bool)
+	for i, raw := range config.Targets {
+		resolveRawSecrets(raw, fmt.Sprintf("targets[%d]", i), problems)
+		target, err := config.targetConfig(raw, isYAML)
+		if err != nil {
+			problems.errorf("解析第 %d 个网关的配置失败: %v", i+1, err)
+			if !isTypeError(err) {
+				continue
+			}
+		}
+		if target.Name == "" || names[target.Name] {
+			problems.errorf("第 %d 个网关的name为空或重复", i+1)
+			continue
+		}
+		names[target.Name] = true
+		applyEnvOverrides(target, targetEnvPrefix(target.Name), problems)
+		target.setDefaults()
+		target.validate(problems, fmt.Sprintf("网关 %s: ", target.Name))
+		config.targets = append(config.targets, target)
+	}
+
+	if len(problems.Errors) > 0 {
+		return nil, problems
+	}
+	for _, warning := range problems.Warnings {
+		log.Printf("\033[33m配置警告：%s\033[0m\n", warning)
+	}
+	config.warnings = problems.Warnings
+	return &config, nil
+}
+
+// 复制顶层配置并覆盖网关中设置的字段
+func (c *Config) targetConfig(raw map[string]interface{}, isYAML bool) (*Config, error) {
+	base := *c
+	base.Targets = nil
+	base.targets = nil
+	base.Name = ""
 
-	if ext == ".yaml" || ext == ".yml" {
-		if err := yaml.Unmarshal(configData, &config); err != nil {
-			return nil, fmt.Errorf("解析YAML配置文件失败: %v", err)
+	// 通过序列化复制，避免各网关共用同一个map或切片
+	data, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+	var target Config
+	if err := json.Unmarshal(data, &target); err != nil {
+		return nil, err
+	}
+
+	if isYAML {
+		data, err = yaml.Marshal(raw)
+		if err == nil {
+			err = yaml.Unmarshal(data, &target)
 		}
-		fmt.Printf("使用YAML格式配置文件: %s\n", configFile)
 	} else {
-		if err := json.Unmarshal(configData, &config); err != nil {
-			return nil, fmt.Errorf("解析JSON配置文件失败: %v", err)
+		data, err = json.Marshal(raw)
+		if err == nil {
+			err = json.Unmarshal(data, &target)
 		}
-		fmt.Printf("使用JSON格式配置文件: %s\n", configFile)
 	}
+	if err != nil && !isTypeError(err) {
+		return nil, err
+	}
+	// 字段类型不符时同时返回解析出的其余配置
+	return &target, err
+}
 
+// 设置默认值，检查配置见validate
+func (config *Config) setDefaults() {
 	// 设置默认值
 	if config.OneAPIType == "" {
-		config.OneAPIType = "oneapi"
+		config.OneAPIType = BackendAuto
+	}
+
+	if config.AdminUserID == 0 {
+		config.AdminUserID = 1
 	}
 
 	if config.DbType == "" {
@@ -130,5 +288,59 @@ func loadConfig() (*Config, error) {
 		config.Timeout = 10
 	}
 
-	return &config, nil
+	// 不连接数据库时，管理接口不返回渠道的密钥，默认通过网关测试
+	if config.DbDsn == "" && config.Probe.Default == "" {
+		config.Probe.Default = ProbeGateway
+	}
+	if config.Probe.Default == "" {
+		config.Probe.Default = ProbeDirect
+	}
+
+	if config.AbilitiesPolicy == "" {
+		config.AbilitiesPolicy = AbilitiesPolicyDisable
+	}
+
+	// 比例使用指针，配置为0时不会被默认值覆盖
+	if config.OutageGuard.MaxModelFailureRatio == nil {
+		ratio := 0.8
+		config.OutageGuard.MaxModelFailureRatio = &ratio
+	}
+	if config.OutageGuard.MaxChannelFailureRatio == nil {
+		ratio := 0.8
+		config.OutageGuard.MaxChannelFailureRatio = &ratio
+	}
+	if config.OutageGuard.MinModels == 0 {
+		config.OutageGuard.MinModels = 10
+	}
+	if config.OutageGuard.MinChannels == 0 {
+		config.OutageGuard.MinChannels = 3
+	}
+
+	if config.EmptyPolicy == "" {
+		config.EmptyPolicy = EmptyPolicyClear
+	}
+
+	if config.CacheReload.Mode == "" {
+		config.CacheReload.Mode = CacheReloadNone
+	}
+	if config.CacheReload.Method == "" {
+		config.CacheReload.Method = "POST"
+	}
+
+	if config.RoutingAdvisor.Mode == "" {
+		config.RoutingAdvisor.Mode = RoutingModeChannel
+	}
+	if config.RoutingAdvisor.Window == "" {
+		config.RoutingAdvisor.Window = "24h"
+	}
+	if config.RoutingAdvisor.MinSamples == 0 {
+		config.RoutingAdvisor.MinSamples = 3
+	}
+	if config.RoutingAdvisor.MinPriority == 0 && config.RoutingAdvisor.MaxPriority == 0 {
+		config.RoutingAdvisor.MaxPriority = 10
+	}
+	if config.RoutingAdvisor.MinWeight == 0 && config.RoutingAdvisor.MaxWeight == 0 {
+		config.RoutingAdvisor.MinWeight = 1
+		config.RoutingAdvisor.MaxWeight = 10
+	}
 }
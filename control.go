@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 保留的后台任务数量，超出后丢弃最早的任务
+const maxJobs = 100
+
+// Job 以async=true触发的后台任务
+type Job struct {
+	ID         string      `json:"id"`
+	Target     string      `json:"target"`
+	Action     string      `json:"action"`
+	Status     string      `json:"status"` // running、done或failed
+	CreatedAt  time.Time   `json:"created_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+var (
+	jobsMu   sync.Mutex
+	jobs     = make(map[string]*Job)
+	jobOrder []string
+	jobSeq   int
+)
+
+// 在后台执行fn并返回任务
+func startJob(target, action string, fn func() (interface{}, error)) Job {
+	jobsMu.Lock()
+	jobSeq++
+	job := &Job{
+		ID:        fmt.Sprintf("%s-%d", time.Now().Format("20060102-150405"), jobSeq),
+		Target:    target,
+		Action:    action,
+		Status:    "running",
+		CreatedAt: time.Now(),
+	}
+	jobs[job.ID] = job
+	jobOrder = append(jobOrder, job.ID)
+	if len(jobOrder) > maxJobs {
+		delete(jobs, jobOrder[0])
+		jobOrder = jobOrder[1:]
+	}
+	snapshot := *job
+	jobsMu.Unlock()
+
+	go func() {
+		result, err := fn()
+		now := time.Now()
+		jobsMu.Lock()
+		defer jobsMu.Unlock()
+		job.FinishedAt = &now
+		job.Result = result
+		if err != nil {
+			job.Status = "failed"
+			job.Error = err.Error()
+			log.Printf("\033[31m任务 %s(%s) 失败：%v\033[0m\n", job.ID, action, err)
+			return
+		}
+		job.Status = "done"
+	}()
+	return snapshot
+}
+
+func getJob(id string) (Job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	job, ok := jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// 同步执行fn并返回结果，async=true时立即返回任务ID
+func runControlAction(w http.ResponseWriter, r *http.Request, t *Target, action string, fn func() (interface{}, error)) {
+	if r.URL.Query().Get("async") == "true" {
+		writeJSON(w, http.StatusAccepted, startJob(t.Name, action, fn))
+		return
+	}
+	result, err := fn()
+	if errors.Is(err, errChannelNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// 控制接口的公共检查，返回请求对应的网关
+func controlTarget(w http.ResponseWriter, r *http.Request) (*Target, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	if !authorizeControl(w, r) {
+		return nil, false
+	}
+	return targetFromRequest(w, r)
+}
+
+// POST /api/control/run?target=xxx 立即执行一个完整的检测周期
+func handleControlRun(w http.ResponseWriter, r *http.Request) {
+	t, ok := controlTarget(w, r)
+	if !ok {
+		return
+	}
+	runControlAction(w, r, t, "run", func() (interface{}, error) {
+		report := t.runCycle()
+		if report.Error != "" {
+			return report, errors.New(report.Error)
+		}
+		return report, nil
+	})
+}
+
+// POST /api/control/test?target=xxx&channel=12 立即测试一个渠道并按结果更新；
+// 加上model=gpt-4o时只测试该模型，不写入网关
+func handleControlTest(w http.ResponseWriter, r *http.Request) {
+	t, ok := controlTarget(w, r)
+	if !ok {
+		return
+	}
+	channelID, err := strconv.Atoi(r.URL.Query().Get("channel"))
+	if err != nil || channelID <= 0 {
+		http.Error(w, "需要指定channel", http.StatusBadRequest)
+		return
+	}
+	model := strings.TrimSpace(r.URL.Query().Get("model"))
+	if model == "" {
+		runControlAction(w, r, t, "test_channel", func() (interface{}, error) {
+			return t.testChannel(channelID)
+		})
+		return
+	}
+	runControlAction(w, r, t, "test_model", func() (interface{}, error) {
+		return t.testChannelModel(channelID, model)
+	})
+}
+
+// POST /api/control/pause?target=xxx 和 /api/control/resume?target=xxx 暂停或恢复定时检测
+func handleControlPause(w http.ResponseWriter, r *http.Request) {
+	t, ok := controlTarget(w, r)
+	if !ok {
+		return
+	}
+	paused := strings.HasSuffix(r.URL.Path, "/pause")
+	t.paused.Store(paused)
+	if paused {
+		log.Printf("网关 %s 的定时检测已暂停\n", t.Name)
+	} else {
+		log.Printf("网关 %s 的定时检测已恢复\n", t.Name)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"target": t.Name, "paused": paused})
+}
+
+// GET /api/control/jobs/{id} 查询后台任务
+func handleControlJob(w http.ResponseWriter, r *http.Request) {
+	if !authorizeControl(w, r) {
+		return
+	}
+	job, ok := getJob(strings.TrimPrefix(r.URL.Path, "/api/control/jobs/"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "找不到任务"})
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
@@ -11,6 +12,19 @@ import (
 	_ "modernc.org/sqlite" // Pure Go SQLite driver
 )
 
+// OneAPI会同时写入同一个SQLite文件，默认开启WAL并设置忙等待，避免database is locked。
+// DSN中已经带有_pragma参数时按用户的设置为准
+func sqliteDSN(dsn string) string {
+	if strings.Contains(dsn, "_pragma=") {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+}
+
 func NewDB(config Config) (*gorm.DB, error) {
 	var dialector gorm.Dialector
 
@@ -19,7 +33,11 @@ func NewDB(config Config) (*gorm.DB, error) {
 		dialector = mysql.Open(config.DbDsn)
 
 	case "sqlite":
-		dialector = sqlite.Open(config.DbDsn)
+		// 使用纯Go实现的modernc.org/sqlite，CGO_ENABLED=0编译的发布版本也可以使用
+		dialector = sqlite.New(sqlite.Config{
+			DriverName: "sqlite",
+			DSN:        sqliteDSN(config.DbDsn),
+		})
 
 	case "postgres":
 		dialector = postgres.Open(config.DbDsn)
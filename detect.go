@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BackendAuto 启动时自动识别网关类型
+const BackendAuto = "auto"
+
+// BackendInfo 启动时识别到的网关类型和表结构特性
+type BackendInfo struct {
+	Type     string   // 为空表示无法识别
+	Source   string   // database 或 api
+	Features []string // 识别依据，如channels.tag
+	// 数据库缺少监控依赖的表或字段
+	Incompatible bool
+}
+
+// 识别网关类型并确定oneapi_type。以下情况以演练模式运行，拒绝写入：oneapi_type为auto且无法识别、
+// 数据库缺少监控依赖的表或字段、明确配置的类型与识别结果不一致且未设置force_oneapi_type。
+// VoAPI与NewAPI的表结构和接口相同，无法区分，需要手动配置
+func resolveBackend(config *Config, db *gorm.DB) {
+	var info BackendInfo
+	if db != nil {
+		info = detectSchema(db)
+	} else {
+		info = detectStatusAPI(config)
+	}
+
+	if info.Type == "" {
+		if info.Incompatible {
+			log.Printf("\033[31m数据库缺少监控依赖的表或字段：%v\033[0m\n", info.Features)
+			refuseWrites(config, "请检查db_dsn是否指向网关的数据库")
+		} else if config.OneAPIType != BackendAuto {
+			log.Printf("\033[33m无法识别网关类型（来源：%s）：%v，按配置的oneapi_type %s运行\033[0m\n", info.Source, info.Features, config.OneAPIType)
+			return
+		} else {
+			log.Printf("\033[31m无法识别网关类型（来源：%s）：%v\033[0m\n", info.Source, info.Features)
+			refuseWrites(config, "可以手动配置oneapi_type")
+		}
+		if config.OneAPIType == BackendAuto {
+			config.OneAPIType = BackendOneAPI
+		}
+		return
+	}
+
+	log.Printf("检测到网关类型：%s（来源：%s，特性：%v）\n", info.Type, info.Source, info.Features)
+	switch {
+	case config.OneAPIType == BackendAuto:
+		config.OneAPIType = info.Type
+	// rest适用于任何兼容的网关；VoAPI基于NewAPI，检测结果为newapi时不算不一致
+	case config.OneAPIType == info.Type, config.OneAPIType == BackendREST,
+		config.OneAPIType == BackendVoAPI && info.Type == BackendNewAPI:
+	case config.ForceOneAPIType:
+		log.Printf("\033[33m配置的oneapi_type为%s，与检测结果%s不一致，已设置force_oneapi_type，按配置运行\033[0m\n", config.OneAPIType, info.Type)
+	default:
+		log.Printf("\033[31m配置的oneapi_type为%s，与检测结果%s不一致\033[0m\n", config.OneAPIType, info.Type)
+		refuseWrites(config, "确认配置无误时可以设置force_oneapi_type")
+	}
+}
+
+// 以演练模式运行，hint提示如何解决
+func refuseWrites(config *Config, hint string) {
+	if !config.DoNotModifyDb {
+		log.Printf("\033[31m为避免写坏数据，本次运行不会写入任何变更，%s\033[0m\n", hint)
+		config.DoNotModifyDb = true
+	}
+}
+
+// 根据数据库中的表和字段判断网关类型
+func detectSchema(db *gorm.DB) BackendInfo {
+	info := BackendInfo{Source: "database"}
+	m := db.Migrator()
+
+	// 监控依赖的表和字段
+	for _, column := range []string{"id", "type", "key", "status", "models", "group"} {
+		if !m.HasColumn("channels", column) {
+			info.Features = append(info.Features, "缺少channels."+column)
+		}
+	}
+	for _, column := range []string{"group", "model", "channel_id", "enabled"} {
+		if !m.HasColumn("abilities", column) {
+			info.Features = append(info.Features, "缺少abilities."+column)
+		}
+	}
+	if len(info.Features) > 0 {
+		info.Incompatible = true
+		return info
+	}
+
+	for _, feature := range []struct{ table, column string }{
+		{"channels", "tag"},
+		{"channels", "channel_info"},
+		{"abilities", "tag"},
+		{"abilities", "weight"},
+	} {
+		if m.HasColumn(feature.table, feature.column) {
+			info.Features = append(info.Features, feature.table+"."+feature.column)
+		}
+	}
+	// OneHub特有的表
+	for _, table := range []string{"telegram_menus", "prices"} {
+		if m.HasTable(table) {
+			info.Features = append(info.Features, table)
+		}
+	}
+
+	switch {
+	case containsString(info.Features, "telegram_menus") || containsString(info.Features, "prices"):
+		info.Type = BackendOneHub
+	case containsString(info.Features, "abilities.tag") || containsString(info.Features, "channels.channel_info"):
+		info.Type = BackendNewAPI
+	default:
+		info.Type = BackendOneAPI
+	}
+	return info
+}
+
+// 根据base_url的/api/status返回的字段判断网关类型
+func detectStatusAPI(config *Config) BackendInfo {
+	info := BackendInfo{Source: "api"}
+	client := &http.Client{Timeout: time.Duration(config.Timeout) * time.Second}
+	resp, err := client.Get(config.BaseURL + "/api/status")
+	if err != nil {
+		info.Features = append(info.Features, err.Error())
+		return info
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	var response struct {
+		Success bool                   `json:"success"`
+		Data    map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || !response.Success {
+		info.Features = append(info.Features, fmt.Sprintf("状态码：%d", resp.StatusCode))
+		return info
+	}
+	if version, ok := response.Data["version"].(string); ok {
+		info.Features = append(info.Features, "version="+version)
+	}
+
+	switch {
+	case response.Data["telegram_bot"] != nil:
+		info.Type = BackendOneHub
+	case response.Data["mj_notify_enabled"] != nil || response.Data["setup"] != nil:
+		info.Type = BackendNewAPI
+	case response.Data["system_name"] != nil:
+		info.Type = BackendOneAPI
+	default:
+		info.Features = append(info.Features, "未知的/api/status字段")
+	}
+	return info
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 环境变量前缀，如CHANNELMONITOR_DB_DSN、CHANNELMONITOR_NOTIFICATION__SMTP__PASSWORD
+const envPrefix = "CHANNELMONITOR_"
+
+// 未通过--config指定配置文件时，从该环境变量读取配置文件路径
+const envConfigPath = envPrefix + "CONFIG"
+
+// 按json标签遍历配置中的每个字段，path为字段的配置路径，不包括targets
+func walkConfigFields(v reflect.Value, path []string, fn func(path []string, field reflect.Value) error) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || name == "targets" {
+			continue
+		}
+		fieldPath := append(append([]string{}, path...), name)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := walkConfigFields(field, fieldPath, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(fieldPath, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 配置路径对应的环境变量名，层级之间用两个下划线分隔
+func envName(prefix string, path []string) string {
+	return prefix + envKey(strings.Join(path, "__"))
+}
+
+// 转为大写，字母数字以外的字符替换为下划线
+func envKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// 是否设置了任何配置相关的环境变量
+func hasEnvOverrides() bool {
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, envPrefix) && !strings.HasPrefix(kv, envConfigPath+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// 用环境变量覆盖配置，prefix为CHANNELMONITOR_或某个网关的CHANNELMONITOR_TARGETS__NAME__。
+// 无效的值记录到problems，继续处理其余的环境变量
+func applyEnvOverrides(c *Config, prefix string, problems *ConfigError) {
+	walkConfigFields(reflect.ValueOf(c).Elem(), nil, func(path []string, field reflect.Value) error {
+		name := envName(prefix, path)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil
+		}
+		value, err := resolveSecret(value)
+		if err != nil {
+			problems.errorf("环境变量 %s: %v", name, err)
+			return nil
+		}
+		if err := setFieldFromString(field, value); err != nil {
+			problems.errorf("环境变量 %s 的值无效: %v", name, err)
+		}
+		return nil
+	})
+}
+
+// 某个网关的环境变量前缀
+func targetEnvPrefix(name string) string {
+	return envPrefix + "TARGETS__" + envKey(name) + "__"
+}
+
+// 将字符串转换为字段的类型，列表可以用逗号分隔，map使用JSON
+func setFieldFromString(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Ptr:
+		elem := reflect.New(field.Type().Elem())
+		if err := setFieldFromString(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+	case reflect.Slice:
+		if strings.HasPrefix(strings.TrimSpace(value), "[") {
+			return json.Unmarshal([]byte(value), field.Addr().Interface())
+		}
+		list := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setFieldFromString(elem, item); err != nil {
+				return err
+			}
+			list = reflect.Append(list, elem)
+		}
+		field.Set(list)
+	case reflect.Map:
+		field.Set(reflect.Zero(field.Type()))
+		return json.Unmarshal([]byte(value), field.Addr().Interface())
+	default:
+		return fmt.Errorf("不支持的类型 %s", field.Type())
+	}
+	return nil
+}
+
+// 密钥引用${file:路径}和${env:变量名}。必须带${}，
+// 以免把file:monitor.db?cache=shared这类SQLite URI当作文件引用
+var secretRefPattern = regexp.MustCompile(`\$\{(file|env):([^}]+)\}`)
+
+// 将值中的${file:...}替换为文件内容，${env:...}替换为环境变量，可以只替换值的一部分，如DSN中的密码
+func resolveSecret(value string) (string, error) {
+	var firstErr error
+	resolved := secretRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		match := secretRefPattern.FindStringSubmatch(ref)
+		kind, name := match[1], match[2]
+		if kind == "file" {
+			data, err := ioutil.ReadFile(name)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("读取文件失败: %v", err)
+				}
+				return ref
+			}
+			// 去掉文件末尾的换行
+			return strings.TrimRight(string(data), "\r\n")
+		}
+		v, ok := os.LookupEnv(name)
+		if !ok && firstErr == nil {
+			firstErr = fmt.Errorf("环境变量 %s 未设置", name)
+		}
+		return v
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return resolved, nil
+}
+
+// 解析配置中所有字符串字段和字符串map中的${file:...}、${env:...}引用
+func (c *Config) resolveSecrets(problems *ConfigError) {
+	walkConfigFields(reflect.ValueOf(c).Elem(), nil, func(path []string, field reflect.Value) error {
+		switch {
+		case field.Kind() == reflect.String:
+			value, err := resolveSecret(field.String())
+			if err != nil {
+				problems.errorf("%s: %v", strings.Join(path, "."), err)
+				return nil
+			}
+			field.SetString(value)
+		case field.Kind() == reflect.Map && field.Type().Elem().Kind() == reflect.String:
+			for _, key := range field.MapKeys() {
+				value, err := resolveSecret(field.MapIndex(key).String())
+				if err != nil {
+					problems.errorf("%s.%s: %v", strings.Join(path, "."), key.String(), err)
+					continue
+				}
+				field.SetMapIndex(key, reflect.ValueOf(value).Convert(field.Type().Elem()))
+			}
+		}
+		return nil
+	})
+}
+
+// 解析网关配置中原始值里的${file:...}、${env:...}引用，path为错误信息中的配置路径
+func resolveRawSecrets(v interface{}, path string, problems *ConfigError) interface{} {
+	switch value := v.(type) {
+	case string:
+		resolved, err := resolveSecret(value)
+		if err != nil {
+			problems.errorf("%s: %v", path, err)
+			return value
+		}
+		return resolved
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = resolveRawSecrets(item, path+"."+k, problems)
+		}
+	}
+	return v
+}
+
+// 找出与任何配置项都不对应的CHANNELMONITOR_环境变量，网关的环境变量按网关名匹配，不在这里检查
+func unknownEnvOverrides() []string {
+	known := make(map[string]bool)
+	walkConfigFields(reflect.ValueOf(&Config{}).Elem(), nil, func(path []string, field reflect.Value) error {
+		known[envName(envPrefix, path)] = true
+		return nil
+	})
+	var unknown []string
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if !strings.HasPrefix(name, envPrefix) || name == envConfigPath || strings.HasPrefix(name, envPrefix+"TARGETS__") || known[name] {
+			continue
+		}
+		unknown = append(unknown, fmt.Sprintf("环境变量 %s 不对应任何配置项", name))
+	}
+	sort.Strings(unknown)
+	return unknown
+}
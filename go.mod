@@ -21,7 +21,10 @@ require (
 	modernc.org/token v1.1.0 // indirect
 )
 
-require golang.org/x/time v0.10.0
+require (
+	github.com/prometheus/client_golang v1.23.2
+	golang.org/x/time v0.10.0
+)
 
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
@@ -38,7 +41,6 @@ require (
 	github.com/kr/text v0.2.0 // indirect
 	github.com/microsoft/go-mssqldb v1.8.0 // indirect
 	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
-	github.com/prometheus/client_golang v1.23.2 // indirect
 	github.com/prometheus/client_model v0.6.2 // indirect
 	github.com/prometheus/common v0.66.1 // indirect
 	github.com/prometheus/procfs v0.16.1 // indirect
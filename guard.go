@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+type OutageGuardConfig struct {
+	Enabled                bool     `json:"enabled" yaml:"enabled"`
+	MaxModelFailureRatio   *float64 `json:"max_model_failure_ratio" yaml:"max_model_failure_ratio"` // 默认0.8，0表示任一模型失败即拦截
+	MaxChannelFailureRatio *float64 `json:"max_channel_failure_ratio" yaml:"max_channel_failure_ratio"`
+	MinModels              int      `json:"min_models" yaml:"min_models"`
+	MinChannels            int      `json:"min_channels" yaml:"min_channels"`
+	CheckURLs              []string `json:"check_urls" yaml:"check_urls"`
+}
+
+// 检查本周期的结果是否可信，失败比例过高或连通性检查失败时返回true并发送告警
+func (t *Target) checkOutageGuard(results []ChannelResult) bool {
+	guard := t.Config().OutageGuard
+	if !guard.Enabled {
+		return false
+	}
+
+	reason := t.outageReason(results)
+	if reason == "" {
+		return false
+	}
+
+	log.Printf("\033[31m网关 %s 疑似监控端故障，本周期不写入数据库：%s\033[0m\n", t.Name, reason)
+	outageGuardTripTotal.WithLabelValues(t.Name).Inc()
+	t.blockPlan(reason)
+
+	msg := fmt.Sprintf("\n疑似监控端故障，本周期未写入数据库\n网关: %s\n原因: %s\n", t.Name, reason)
+	if err := sendAlert("疑似监控端故障", msg); err != nil {
+		log.Printf("发送通知失败: %v", err)
+		notificationTotal.WithLabelValues(t.Name, "outage_guard", "error").Inc()
+	} else {
+		notificationTotal.WithLabelValues(t.Name, "outage_guard", "success").Inc()
+	}
+	return true
+}
+
+func (t *Target) outageReason(results []ChannelResult) string {
+	guard := t.Config().OutageGuard
+
+	var tested, failed, failedChannels int
+	for _, r := range results {
+		tested += r.Tested
+		failed += len(r.Failures)
+		// 未能获取模型列表或没有任何模型通过的渠道
+		if r.Skipped || len(r.Available) == 0 {
+			failedChannels++
+		}
+	}
+
+	if tested >= guard.MinModels && tested > 0 {
+		ratio := float64(failed) / float64(tested)
+		if ratio > *guard.MaxModelFailureRatio {
+			return fmt.Sprintf("%d/%d 个模型测试失败，超过阈值 %.0f%%", failed, tested, *guard.MaxModelFailureRatio*100)
+		}
+	}
+	if len(results) >= guard.MinChannels && len(results) > 0 {
+		ratio := float64(failedChannels) / float64(len(results))
+		if ratio > *guard.MaxChannelFailureRatio {
+			return fmt.Sprintf("%d/%d 个渠道没有可用模型，超过阈值 %.0f%%", failedChannels, len(results), *guard.MaxChannelFailureRatio*100)
+		}
+	}
+
+	// 只有本周期会移除模型或渠道时才需要确认监控端网络，全部通过时不发送额外请求
+	if failed == 0 && failedChannels == 0 {
+		return ""
+	}
+	if len(guard.CheckURLs) > 0 && !t.checkConnectivity(guard.CheckURLs) {
+		return "连通性检查失败，所有检查地址均无法访问"
+	}
+	return ""
+}
+
+// 任一地址可以访问即认为监控端网络正常
+func (t *Target) checkConnectivity(urls []string) bool {
+	client := &http.Client{Timeout: time.Duration(t.Config().Timeout) * time.Second}
+	for _, url := range urls {
+		resp, err := client.Get(url)
+		if err != nil {
+			log.Printf("连通性检查 %s 失败：%v\n", url, err)
+			continue
+		}
+		resp.Body.Close()
+		return true
+	}
+	return false
+}
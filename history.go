@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type HistoryConfig struct {
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+	DbType  string `json:"db_type" yaml:"db_type"` // 为空时使用OneAPI的数据库
+	DbDsn   string `json:"db_dsn" yaml:"db_dsn"`
+}
+
+// ReasonRollback 回滚产生的历史记录的原因分类
+const ReasonRollback = "rollback"
+
+// HistoryRecord 一次渠道变更的审计记录
+type HistoryRecord struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Target      string    `gorm:"size:64;index" json:"target"`
+	CycleID     string    `gorm:"size:32;index" json:"cycle_id"`
+	ChannelID   int       `gorm:"index" json:"channel_id"`
+	ChannelName string    `gorm:"size:255" json:"channel_name"`
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+	OldModels   string    `gorm:"type:text" json:"old_models"`
+	NewModels   string    `gorm:"type:text" json:"new_models"`
+	Abilities   string    `gorm:"type:text" json:"abilities"` // []AbilityChange 的JSON
+	Reasons     string    `gorm:"type:text" json:"reasons"`   // 被移除的模型 -> 失败原因分类 的JSON
+	OldStatus   int       `json:"old_status"`
+	NewStatus   int       `json:"new_status"`
+	EmptyPolicy string    `gorm:"size:16" json:"empty_policy"` // 本次变更按empty_policy处理时的策略
+}
+
+func (HistoryRecord) TableName() string {
+	return "channel_monitor_history"
+}
+
+// 连接历史记录所在的数据库并建表
+func (t *Target) initHistory() error {
+	if !t.Config().History.Enabled {
+		return nil
+	}
+	t.HistoryDB = t.DB
+	if t.Config().History.DbType != "" {
+		var err error
+		t.HistoryDB, err = NewDB(Config{DbType: t.Config().History.DbType, DbDsn: t.Config().History.DbDsn})
+		if err != nil {
+			return fmt.Errorf("连接历史记录数据库失败: %v", err)
+		}
+	}
+	if t.HistoryDB == nil {
+		return fmt.Errorf("未配置db_dsn时需要为history单独配置db_type和db_dsn")
+	}
+	return t.HistoryDB.AutoMigrate(&HistoryRecord{})
+}
+
+// 只查询本网关的记录，多个网关可以共用同一个历史数据库
+func (t *Target) historyQuery() *gorm.DB {
+	return t.HistoryDB.Where("target = ?", t.Name)
+}
+
+// 渠道最近一次状态变更是否为本监控按empty_policy: disable自动禁用。
+// 之后被重新启用、再由网关禁用的渠道没有记录，最近一次变更仍是启用，不会误判。
+// 未启用变更历史时只能识别本进程运行期间禁用的渠道
+func (t *Target) disabledByEmptyPolicy(channelID int) bool {
+	if t.HistoryDB == nil {
+		t.emptyMu.Lock()
+		defer t.emptyMu.Unlock()
+		return t.emptyDisabled[channelID]
+	}
+	var record HistoryRecord
+	err := t.historyQuery().
+		Where("channel_id = ? AND old_status <> new_status", channelID).
+		Order("id DESC").First(&record).Error
+	if err != nil {
+		return false
+	}
+	return record.NewStatus == ChannelStatusAutoDisabled && record.EmptyPolicy == EmptyPolicyDisable
+}
+
+// 记录一次已写入数据库的渠道变更
+func (t *Target) recordHistory(plan ChannelPlan) {
+	if t.HistoryDB == nil {
+		return
+	}
+	abilities, _ := json.Marshal(plan.Abilities)
+	reasons, _ := json.Marshal(plan.Reasons)
+	record := HistoryRecord{
+		Target:      t.Name,
+		CycleID:     plan.CycleID,
+		ChannelID:   plan.ChannelID,
+		ChannelName: plan.ChannelName,
+		OldModels:   strings.Join(plan.OldModels, ","),
+		NewModels:   strings.Join(plan.NewModels, ","),
+		Abilities:   string(abilities),
+		Reasons:     string(reasons),
+		OldStatus:   plan.OldStatus,
+		NewStatus:   plan.NewStatus,
+		EmptyPolicy: plan.EmptyPolicy,
+	}
+	if err := t.HistoryDB.Create(&record).Error; err != nil {
+		log.Printf("\033[31m记录渠道 %s(ID:%d) 的变更历史失败：%v\033[0m\n", plan.ChannelName, plan.ChannelID, err)
+	}
+}
+
+func (t *Target) listHistory(channelID int, cycleID string, limit int) ([]HistoryRecord, error) {
+	if t.HistoryDB == nil {
+		return nil, fmt.Errorf("未启用变更历史")
+	}
+	query := t.historyQuery().Order("id DESC")
+	if channelID > 0 {
+		query = query.Where("channel_id = ?", channelID)
+	}
+	if cycleID != "" {
+		query = query.Where("cycle_id = ?", cycleID)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var records []HistoryRecord
+	err := query.Find(&records).Error
+	return records, err
+}
+
+// 将渠道恢复到某条记录变更之前的模型列表、状态和abilities行，cycleID不为空时恢复该周期内所有渠道
+func (t *Target) rollback(recordID uint, cycleID string) ([]ChannelPlan, error) {
+	if t.HistoryDB == nil {
+		return nil, fmt.Errorf("未启用变更历史")
+	}
+
+	var records []HistoryRecord
+	if cycleID != "" {
+		// 同一周期内每个渠道只有一条记录
+		if err := t.historyQuery().Where("cycle_id = ?", cycleID).Order("id").Find(&records).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		var record HistoryRecord
+		if err := t.historyQuery().First(&record, recordID).Error; err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("找不到对应的变更记录")
+	}
+
+	rollbackID := newPlanID("rollback-")
+	var plans []ChannelPlan
+	for _, record := range records {
+		plan, err := t.planRollback(record)
+		if err != nil {
+			return plans, fmt.Errorf("计算渠道 %d 的回滚变更失败: %v", record.ChannelID, err)
+		}
+		plan.CycleID = rollbackID
+		if plan.hasChanges() {
+			if err := t.applyChannelPlan(plan); err != nil {
+				t.flushCacheReload()
+				return plans, fmt.Errorf("回滚渠道 %d 失败: %v", record.ChannelID, err)
+			}
+			t.recordHistory(plan)
+		}
+		log.Printf("渠道 %s(ID:%d) 已回滚到记录 %d 之前的模型：%v\n", record.ChannelName, record.ChannelID, record.ID, plan.NewModels)
+		plans = append(plans, plan)
+	}
+	t.flushCacheReload()
+	return plans, nil
+}
+
+// 按记录计算回滚的变更：模型列表和状态恢复为变更前的值，abilities行按相反的顺序撤销
+// 该记录及之后所有记录中的变更，不重新计算，以保留管理员设置的priority等字段
+func (t *Target) planRollback(record HistoryRecord) (ChannelPlan, error) {
+	state, err := t.Backend().ReadChannel(record.ChannelID)
+	if err != nil {
+		return ChannelPlan{}, err
+	}
+	var records []HistoryRecord
+	err = t.historyQuery().Where("channel_id = ? AND id > ?", record.ChannelID, record.ID).Order("id DESC").Find(&records).Error
+	if err != nil {
+		return ChannelPlan{}, err
+	}
+	var abilities []AbilityChange
+	for _, r := range append(records, record) {
+		var recorded []AbilityChange
+		if r.Abilities != "" {
+			if err := json.Unmarshal([]byte(r.Abilities), &recorded); err != nil {
+				return ChannelPlan{}, fmt.Errorf("解析记录 %d 的abilities变更失败: %v", r.ID, err)
+			}
+		}
+		abilities = append(abilities, revertAbilities(recorded)...)
+	}
+
+	newModels := splitList(record.OldModels)
+	added, removed := compareModels(state.Models, newModels)
+	plan := ChannelPlan{
+		ChannelID:     record.ChannelID,
+		ChannelName:   record.ChannelName,
+		OldModels:     state.Models,
+		NewModels:     newModels,
+		AddedModels:   added,
+		RemovedModels: removed,
+		Abilities:     abilities,
+		Reasons:       make(map[string]string),
+		OldStatus:     state.Status,
+		NewStatus:     record.OldStatus, // 同时恢复渠道状态
+	}
+	for _, model := range plan.RemovedModels {
+		plan.Reasons[model] = ReasonRollback
+	}
+	return plan, nil
+}
+
+// 按相反的顺序撤销abilities变更，被禁用、启用或删除的行按记录的内容恢复。
+// 插入的行没有记录内容，回滚时删除该行，再次回滚时按渠道的priority重新插入
+func revertAbilities(changes []AbilityChange) []AbilityChange {
+	var reverted []AbilityChange
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := changes[i]
+		switch c.Action {
+		case "insert", "restore":
+			reverted = append(reverted, AbilityChange{Group: c.Group, Model: c.Model, Action: "delete"})
+		case "enable", "disable":
+			// 撤销时的行即变更后的行，回滚记录本身也可以再次回滚
+			after := *c.Before
+			after.Enabled = c.Action == "enable"
+			change := abilityChange(abilityKey{Group: c.Group, Model: c.Model}, c.Before.Enabled)
+			change.Before = &after
+			reverted = append(reverted, change)
+		case "delete":
+			reverted = append(reverted, AbilityChange{Group: c.Group, Model: c.Model, Action: "restore", Before: c.Before})
+		}
+	}
+	return reverted
+}
+
+// 需要在请求头中携带 Authorization: Bearer <control_token>
+func authorizeControl(w http.ResponseWriter, r *http.Request) bool {
+	token := currentConfig().ControlToken
+	if token == "" {
+		http.Error(w, "未配置control_token，控制接口已禁用", http.StatusForbidden)
+		return false
+	}
+	if r.Header.Get("Authorization") != "Bearer "+token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// GET /api/history?target=xxx&channel=12&cycle=xxx&limit=50
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	t, ok := targetFromRequest(w, r)
+	if !ok {
+		return
+	}
+	channelID, _ := strconv.Atoi(r.URL.Query().Get("channel"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit == 0 {
+		limit = 100
+	}
+	records, err := t.listHistory(channelID, r.URL.Query().Get("cycle"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+// POST /api/rollback?target=xxx&record=ID 或 POST /api/rollback?target=xxx&cycle=CYCLE_ID
+func handleRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeControl(w, r) {
+		return
+	}
+	t, ok := targetFromRequest(w, r)
+	if !ok {
+		return
+	}
+	recordID, _ := strconv.ParseUint(r.URL.Query().Get("record"), 10, 64)
+	cycleID := r.URL.Query().Get("cycle")
+	if recordID == 0 && cycleID == "" {
+		http.Error(w, "需要指定record或cycle", http.StatusBadRequest)
+		return
+	}
+
+	// 与检测周期的数据库写入互斥
+	t.updateMu.Lock()
+	plans, err := t.rollback(uint(recordID), cycleID)
+	t.updateMu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plans)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// 按测试通过的模型计算并写入一次变更，与检测周期的写入相同
+func applyTestPlan(t *testing.T, target *Target, channel Channel, models []string) ChannelPlan {
+	t.Helper()
+	plan, err := target.planChannel(channel, models, nil, nil)
+	if err != nil {
+		t.Fatalf("planChannel: %v", err)
+	}
+	plan.CycleID = newPlanID("test-")
+	if err := target.applyChannelPlan(plan); err != nil {
+		t.Fatalf("applyChannelPlan: %v", err)
+	}
+	target.recordHistory(plan)
+	return plan
+}
+
+func TestRollbackRevertsLaterRecords(t *testing.T) {
+	target, db := newTestTarget(t, Config{OneAPIType: BackendOneAPI, History: HistoryConfig{Enabled: true}}, oneAPISchema,
+		"INSERT INTO channels (id, type, `key`, status, name, models, `group`, priority) VALUES (1, 1, 'sk-1', 1, 'openai', 'a,b', 'default', 3)",
+		"INSERT INTO abilities VALUES ('default', 'a', 1, 1, 7), ('default', 'b', 1, 1, 7)",
+	)
+	channel := Channel{ID: 1, Name: "openai"}
+
+	// b测试失败被移除，之后的周期新增了c
+	applyTestPlan(t, target, channel, []string{"a"})
+	applyTestPlan(t, target, channel, []string{"a", "c"})
+	if _, ok := readAbilities(t, db, 1)["default/c"]; !ok {
+		t.Fatalf("第二次变更后应插入c的abilities行")
+	}
+
+	records, err := target.listHistory(1, "", 0)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("listHistory 返回 %d 条记录, %v", len(records), err)
+	}
+	// 回滚较早的记录时，之后记录中的变更一并撤销
+	if _, err := target.rollback(records[1].ID, ""); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+
+	state, err := target.Backend().ReadChannel(1)
+	if err != nil {
+		t.Fatalf("ReadChannel: %v", err)
+	}
+	if got := strings.Join(state.Models, ","); got != "a,b" {
+		t.Errorf("回滚后渠道模型为 %s，期望 a,b", got)
+	}
+	abilities := readAbilities(t, db, 1)
+	if _, ok := abilities["default/c"]; ok {
+		t.Errorf("回滚后不应保留之后插入的c")
+	}
+	for _, key := range []string{"default/a", "default/b"} {
+		if a := abilities[key]; !a.Enabled || a.Priority != 7 {
+			t.Errorf("回滚后 %s 应恢复为启用且priority为7: %+v", key, a)
+		}
+	}
+}
+
+func TestEmptyPolicyOnlyReenablesChannelsItDisabled(t *testing.T) {
+	target, db := newTestTarget(t, Config{OneAPIType: BackendOneAPI, EmptyPolicy: EmptyPolicyDisable, History: HistoryConfig{Enabled: true}}, oneAPISchema,
+		"INSERT INTO channels (id, type, `key`, status, name, models, `group`) VALUES (1, 1, 'sk-1', 1, 'openai', 'a', 'default')",
+		"INSERT INTO abilities VALUES ('default', 'a', 1, 1, 0)",
+	)
+	channel := Channel{ID: 1, Name: "openai"}
+
+	// 没有模型通过时被本监控禁用，恢复后重新启用
+	if plan := applyTestPlan(t, target, channel, nil); plan.NewStatus != ChannelStatusAutoDisabled {
+		t.Fatalf("没有模型通过时应禁用渠道，得到状态 %d", plan.NewStatus)
+	}
+	if plan := applyTestPlan(t, target, channel, []string{"a"}); plan.NewStatus != ChannelStatusEnabled {
+		t.Fatalf("本监控禁用的渠道恢复后应重新启用，得到状态 %d", plan.NewStatus)
+	}
+
+	// 之后网关自行禁用了渠道，没有变更记录
+	if err := db.Exec("UPDATE channels SET status = ? WHERE id = 1", ChannelStatusAutoDisabled).Error; err != nil {
+		t.Fatalf("更新渠道状态失败: %v", err)
+	}
+	plan, err := target.planChannel(channel, []string{"a"}, nil, nil)
+	if err != nil {
+		t.Fatalf("planChannel: %v", err)
+	}
+	if plan.NewStatus != ChannelStatusAutoDisabled {
+		t.Errorf("网关禁用的渠道不应被重新启用，得到状态 %d", plan.NewStatus)
+	}
+}
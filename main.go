@@ -4,17 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"golang.org/x/time/rate"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"gorm.io/gorm"
 )
 
 // Prometheus metrics
@@ -25,7 +26,7 @@ var (
 			Name: "channel_status",
 			Help: "Current status of channels (1 = active, 0 = inactive)",
 		},
-		[]string{"channel_id", "channel_name", "channel_type"},
+		[]string{"target", "channel_id", "channel_name", "channel_type"},
 	)
 
 	channelTestTotal = prometheus.NewCounterVec(
@@ -33,7 +34,7 @@ var (
 			Name: "channel_test_total",
 			Help: "Total number of channel tests",
 		},
-		[]string{"channel_id", "channel_name", "status"},
+		[]string{"target", "channel_id", "channel_name", "status"},
 	)
 
 	// 模型相关指标
@@ -42,7 +43,7 @@ var (
 			Name: "model_test_total",
 			Help: "Total number of model tests",
 		},
-		[]string{"channel_id", "channel_name", "model", "status"},
+		[]string{"target", "channel_id", "channel_name", "model", "status"},
 	)
 
 	modelAvailability = prometheus.NewGaugeVec(
@@ -50,7 +51,7 @@ var (
 			Name: "model_availability",
 			Help: "Model availability (1 = available, 0 = unavailable)",
 		},
-		[]string{"channel_id", "channel_name", "model"},
+		[]string{"target", "channel_id", "channel_name", "model"},
 	)
 
 	modelResponseTime = prometheus.NewHistogramVec(
@@ -59,30 +60,41 @@ var (
 			Help:    "Response time for model tests in seconds",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"channel_id", "channel_name", "model"},
+		[]string{"target", "channel_id", "channel_name", "model"},
+	)
+
+	modelProtectedFailure = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "model_protected_failure",
+			Help: "Protected model failed its test but was kept (1 = failed and kept, 0 = passed)",
+		},
+		[]string{"target", "channel_id", "channel_name", "model"},
 	)
 
 	// 系统相关指标
-	testCycleTotal = prometheus.NewCounter(
+	testCycleTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "test_cycle_total",
 			Help: "Total number of test cycles completed",
 		},
+		[]string{"target"},
 	)
 
-	testCycleDuration = prometheus.NewHistogram(
+	testCycleDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "test_cycle_duration_seconds",
 			Help:    "Duration of test cycles in seconds",
 			Buckets: prometheus.DefBuckets,
 		},
+		[]string{"target"},
 	)
 
-	activeChannelsGauge = prometheus.NewGauge(
+	activeChannelsGauge = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "active_channels_total",
 			Help: "Total number of active channels",
 		},
+		[]string{"target"},
 	)
 
 	availableModelsGauge = prometheus.NewGaugeVec(
@@ -90,7 +102,7 @@ var (
 			Name: "available_models_total",
 			Help: "Total number of available models per channel",
 		},
-		[]string{"channel_id", "channel_name"},
+		[]string{"target", "channel_id", "channel_name"},
 	)
 
 	// 数据库操作指标
@@ -99,7 +111,7 @@ var (
 			Name: "database_operation_total",
 			Help: "Total number of database operations",
 		},
-		[]string{"operation", "status"},
+		[]string{"target", "operation", "status"},
 	)
 
 	dbOperationDuration = prometheus.NewHistogramVec(
@@ -108,7 +120,7 @@ var (
 			Help:    "Duration of database operations in seconds",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"operation"},
+		[]string{"target", "operation"},
 	)
 
 	// 通知相关指标
@@ -117,7 +129,16 @@ var (
 			Name: "notification_total",
 			Help: "Total number of notifications sent",
 		},
-		[]string{"type", "status"},
+		[]string{"target", "type", "status"},
+	)
+
+	// 监控端故障保护指标
+	outageGuardTripTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outage_guard_trip_total",
+			Help: "Total number of cycles whose database writes were blocked by the outage guard",
+		},
+		[]string{"target"},
 	)
 
 	// UptimeKuma推送指标
@@ -126,7 +147,7 @@ var (
 			Name: "uptimekuma_push_total",
 			Help: "Total number of UptimeKuma pushes",
 		},
-		[]string{"type", "status"},
+		[]string{"target", "type", "status"},
 	)
 )
 
@@ -138,6 +159,7 @@ func init() {
 		modelTestTotal,
 		modelAvailability,
 		modelResponseTime,
+		modelProtectedFailure,
 		testCycleTotal,
 		testCycleDuration,
 		activeChannelsGauge,
@@ -145,10 +167,18 @@ func init() {
 		dbOperationTotal,
 		dbOperationDuration,
 		notificationTotal,
+		outageGuardTripTotal,
 		uptimeKumaPushTotal,
 	)
 }
 
+// 渠道状态，与OneAPI一致
+const (
+	ChannelStatusEnabled          = 1
+	ChannelStatusManuallyDisabled = 2
+	ChannelStatusAutoDisabled     = 3
+)
+
 type Channel struct {
 	ID           int
 	Type         int
@@ -159,40 +189,43 @@ type Channel struct {
 	ModelMapping map[string]string
 }
 
-var (
-	db     *gorm.DB
-	config *Config
-)
+// ChannelResult 单个渠道本周期的测试结果
+type ChannelResult struct {
+	Channel      Channel
+	Skipped      bool              // 未能获取模型列表，本周期不更新
+	Tested       int               // 测试的模型数量
+	Available    []string          // 测试通过的模型（上游模型名）
+	Failures     map[string]string // 失败的模型 -> 失败原因分类
+	ModelMapping map[string]string
+}
+
+// 顶层配置，metrics_port、notification、control_token等为整个进程共用。
+// 重新加载时整体替换，通过currentConfig读取，不直接修改已发布的配置
+var activeConfig atomic.Pointer[Config]
 
-func fetchChannels() ([]Channel, error) {
+func currentConfig() *Config {
+	return activeConfig.Load()
+}
+
+func setConfig(c *Config) {
+	activeConfig.Store(c)
+}
+
+func (t *Target) fetchChannels() ([]Channel, error) {
 	startTime := time.Now()
 	defer func() {
-		dbOperationDuration.WithLabelValues("fetch_channels").Observe(time.Since(startTime).Seconds())
+		dbOperationDuration.WithLabelValues(t.Name, "fetch_channels").Observe(time.Since(startTime).Seconds())
 	}()
 
-	query := "SELECT id, type, name, base_url, `key`, status, model_mapping FROM channels"
-	rows, err := db.Raw(query).Rows()
+	all, err := t.Backend().ListChannels()
 	if err != nil {
-		dbOperationTotal.WithLabelValues("fetch_channels", "error").Inc()
+		dbOperationTotal.WithLabelValues(t.Name, "fetch_channels", "error").Inc()
 		return nil, err
 	}
-	defer rows.Close()
-	dbOperationTotal.WithLabelValues("fetch_channels", "success").Inc()
+	dbOperationTotal.WithLabelValues(t.Name, "fetch_channels", "success").Inc()
 
 	var channels []Channel
-	for rows.Next() {
-		var c Channel
-		var modelMapping string
-		if err := rows.Scan(&c.ID, &c.Type, &c.Name, &c.BaseURL, &c.Key, &c.Status, &modelMapping); err != nil {
-			return nil, err
-		}
-		c.ModelMapping = make(map[string]string)
-		if modelMapping != "" {
-			if err := json.Unmarshal([]byte(modelMapping), &c.ModelMapping); err != nil {
-				return nil, err
-			}
-		}
-
+	for _, c := range all {
 		switch c.Type {
 		case 40:
 			c.BaseURL = "https://api.siliconflow.cn"
@@ -204,24 +237,25 @@ func fetchChannels() ([]Channel, error) {
 			}
 		}
 		// 检查是否在排除列表中
-		if contains(config.ExcludeChannel, c.ID) {
+		if contains(t.Config().ExcludeChannel, c.ID) {
 			log.Printf("渠道 %s(ID:%d) 在排除列表中，跳过\n", c.Name, c.ID)
 			continue
 		}
-		
+
 		// 更新渠道状态指标
 		channelStatus.WithLabelValues(
+			t.Name,
 			fmt.Sprintf("%d", c.ID),
 			c.Name,
 			fmt.Sprintf("%d", c.Type),
 		).Set(float64(c.Status))
-		
+
 		channels = append(channels, c)
 	}
-	
+
 	// 更新活跃渠道数量
-	activeChannelsGauge.Set(float64(len(channels)))
-	
+	activeChannelsGauge.WithLabelValues(t.Name).Set(float64(len(channels)))
+
 	log.Printf("获取到 %d 个渠道\n", len(channels))
 	log.Printf("准备测试的渠道：%v\n", channels)
 	return channels, nil
@@ -245,36 +279,48 @@ func containsString(slice []string, item string) bool {
 	return false
 }
 
-func testModels(channel Channel, wg *sync.WaitGroup, mu *sync.Mutex) {
+// pushUptime为false时不推送UptimeKuma，手动测试不应被当作定时检测的心跳
+func (t *Target) testModels(channel Channel, pushUptime bool, wg *sync.WaitGroup, mu *sync.Mutex, results *[]ChannelResult) {
 	defer wg.Done()
 
 	var availableModels []string
+	failures := make(map[string]string) // 失败的模型 -> 失败原因分类
 	modelList := []string{}
-	
+
+	// 提前返回时视为跳过
+	result := ChannelResult{Channel: channel, Skipped: true, Failures: failures}
+	defer func() {
+		mu.Lock()
+		*results = append(*results, result)
+		mu.Unlock()
+	}()
+
 	// 记录渠道测试
 	channelTestTotal.WithLabelValues(
+		t.Name,
 		fmt.Sprintf("%d", channel.ID),
 		channel.Name,
 		"started",
 	).Inc()
-	
-	if config.ForceModels {
+
+	if t.Config().ForceModels {
 		log.Println("强制使用自定义模型列表")
-		modelList = config.Models
+		modelList = t.Config().Models
 	} else {
-		if config.ForceInsideModels {
+		// 网关探测使用网关中配置的模型名，上游通常也不提供OpenAI格式的/v1/models
+		if t.Config().ForceInsideModels || t.probeModeFor(channel) == ProbeGateway {
 			log.Println("强制使用内置模型列表")
-			// 从数据库获取模型列表
-			var models string
+			// 从网关获取模型列表
 			startTime := time.Now()
-			if err := db.Raw("SELECT models FROM channels WHERE id = ?", channel.ID).Scan(&models).Error; err != nil {
-				dbOperationTotal.WithLabelValues("get_models", "error").Inc()
+			state, err := t.Backend().ReadChannel(channel.ID)
+			if err != nil {
+				dbOperationTotal.WithLabelValues(t.Name, "get_models", "error").Inc()
 				log.Printf("获取渠道 %s(ID:%d) 的模型列表失败：%v\n", channel.Name, channel.ID, err)
 				return
 			}
-			dbOperationDuration.WithLabelValues("get_models").Observe(time.Since(startTime).Seconds())
-			dbOperationTotal.WithLabelValues("get_models", "success").Inc()
-			modelList = strings.Split(models, ",")
+			dbOperationDuration.WithLabelValues(t.Name, "get_models").Observe(time.Since(startTime).Seconds())
+			dbOperationTotal.WithLabelValues(t.Name, "get_models", "success").Inc()
+			modelList = state.Models
 		} else {
 			// 从/v1/models接口获取模型列表
 			req, err := http.NewRequest("GET", channel.BaseURL+"/v1/models", nil)
@@ -288,7 +334,7 @@ func testModels(channel Channel, wg *sync.WaitGroup, mu *sync.Mutex) {
 			resp, err := client.Do(req)
 			if err != nil {
 				log.Println("获取模型列表失败：", err, "尝试自定义模型列表")
-				modelList = config.Models
+				modelList = t.Config().Models
 			} else {
 				defer resp.Body.Close()
 				body, _ := ioutil.ReadAll(resp.Body)
@@ -310,7 +356,7 @@ func testModels(channel Channel, wg *sync.WaitGroup, mu *sync.Mutex) {
 				}
 				// 提取模型ID列表
 				for _, model := range response.Data {
-					if containsString(config.ExcludeModel, model.ID) {
+					if containsString(t.Config().ExcludeModel, model.ID) {
 						log.Printf("模型 %s 在排除列表中，跳过\n", model.ID)
 						continue
 					}
@@ -319,12 +365,16 @@ func testModels(channel Channel, wg *sync.WaitGroup, mu *sync.Mutex) {
 			}
 		}
 	}
-	// 测试模型并发处理
+	// 网关探测得到的已经是网关对外的模型名，无需反向映射
+	modelMapping := channel.ModelMapping
+	if t.probeModeFor(channel) == ProbeGateway {
+		modelMapping = nil
+	}
+
+	// 测试模型并发处理，同一渠道的定时和手动测试共用并发和限流
 	modelWg := sync.WaitGroup{}
 	modelMu := sync.Mutex{}
-	sem := make(chan struct{}, config.MaxConcurrent)
-
-	limiter := rate.NewLimiter(rate.Limit(config.RPS), config.RPS)
+	sem, limiter := t.channelLimits(channel.ID)
 
 	for _, model := range modelList {
 		modelWg.Add(1)
@@ -336,119 +386,77 @@ func testModels(channel Channel, wg *sync.WaitGroup, mu *sync.Mutex) {
 			// 限流
 			limiter.Wait(context.Background())
 
-			url := channel.BaseURL
-			if !strings.Contains(channel.BaseURL, "/v1/chat/completions") {
-				if !strings.HasSuffix(channel.BaseURL, "/chat") {
-					if !strings.HasSuffix(channel.BaseURL, "/v1") {
-						url += "/v1"
-					}
-					url += "/chat"
-				}
-				url += "/completions"
-			}
-
-			// 构造请求
-			reqBody := map[string]interface{}{
-				"model": model,
-				"messages": []map[string]string{
-					{"role": "user", "content": "Hi"},
-				},
-				"max_tokens": 1,
-			}
-			jsonData, _ := json.Marshal(reqBody)
-
 			log.Printf("测试渠道 %s(ID:%d) 的模型 %s\n", channel.Name, channel.ID, model)
 
-			req, err := http.NewRequest("POST", url, strings.NewReader(string(jsonData)))
-			if err != nil {
-				log.Printf("创建请求失败：%v\n", err)
-				modelTestTotal.WithLabelValues(
-					fmt.Sprintf("%d", channel.ID),
-					channel.Name,
-					model,
-					"error",
-				).Inc()
-				modelAvailability.WithLabelValues(
-					fmt.Sprintf("%d", channel.ID),
-					channel.Name,
-					model,
-				).Set(0)
-				return
-			}
-			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("Authorization", "Bearer "+channel.Key)
-
-			// 记录响应时间
-			startTime := time.Now()
-			client := &http.Client{Timeout: time.Duration(config.Timeout) * time.Second}
-			resp, err := client.Do(req)
-			responseTime := time.Since(startTime).Seconds()
-			
-			if err != nil {
-				log.Printf("\033[31m请求失败：%v\033[0m\n", err)
-				modelTestTotal.WithLabelValues(
-					fmt.Sprintf("%d", channel.ID),
-					channel.Name,
-					model,
-					"error",
-				).Inc()
-				modelAvailability.WithLabelValues(
-					fmt.Sprintf("%d", channel.ID),
-					channel.Name,
-					model,
-				).Set(0)
-				return
-			}
-			defer resp.Body.Close()
-
-			body, _ := ioutil.ReadAll(resp.Body)
-			if resp.StatusCode == http.StatusOK {
+			result := t.probeModel(channel, model)
+			t.recordRoutingSample(channel.ID, publicModelName(modelMapping, model), result)
+			t.recordProbe(channel, publicModelName(modelMapping, model), result)
+			t.recordModelStatus(channel, publicModelName(modelMapping, model), result)
+			if result.Success {
 				// 根据返回内容判断是否成功
 				modelMu.Lock()
 				availableModels = append(availableModels, model)
 				modelMu.Unlock()
-				
+
 				// 更新指标
 				modelTestTotal.WithLabelValues(
+					t.Name,
 					fmt.Sprintf("%d", channel.ID),
 					channel.Name,
 					model,
 					"success",
 				).Inc()
 				modelAvailability.WithLabelValues(
+					t.Name,
 					fmt.Sprintf("%d", channel.ID),
 					channel.Name,
 					model,
 				).Set(1)
 				modelResponseTime.WithLabelValues(
+					t.Name,
 					fmt.Sprintf("%d", channel.ID),
 					channel.Name,
 					model,
-				).Observe(responseTime)
-				
+				).Observe(result.Latency)
+
 				log.Printf("\033[32m渠道 %s(ID:%d) 的模型 %s 测试成功\033[0m\n", channel.Name, channel.ID, model)
 				// 推送UptimeKuma
-				if err := pushModelUptime(model); err != nil {
-					log.Printf("\033[31m推送UptimeKuma失败：%v\033[0m\n", err)
-					uptimeKumaPushTotal.WithLabelValues("model", "error").Inc()
-				} else {
-					uptimeKumaPushTotal.WithLabelValues("model", "success").Inc()
+				if pushUptime {
+					if err := t.pushModelUptime(model); err != nil {
+						log.Printf("\033[31m推送UptimeKuma失败：%v\033[0m\n", err)
+						uptimeKumaPushTotal.WithLabelValues(t.Name, "model", "error").Inc()
+					} else {
+						uptimeKumaPushTotal.WithLabelValues(t.Name, "model", "success").Inc()
+					}
+					if err := t.pushChannelUptime(channel.ID); err != nil {
+						log.Printf("\033[31m推送UptimeKuma失败：%v\033[0m\n", err)
+						uptimeKumaPushTotal.WithLabelValues(t.Name, "channel", "error").Inc()
+					} else {
+						uptimeKumaPushTotal.WithLabelValues(t.Name, "channel", "success").Inc()
+					}
 				}
-				if err := pushChannelUptime(channel.ID); err != nil {
-					log.Printf("\033[31m推送UptimeKuma失败：%v\033[0m\n", err)
-					uptimeKumaPushTotal.WithLabelValues("channel", "error").Inc()
+			} else {
+				modelMu.Lock()
+				failures[model] = result.ErrorClass()
+				modelMu.Unlock()
+
+				status := "failed"
+				if result.StatusCode == 0 {
+					// 未收到响应
+					status = "error"
+					log.Printf("\033[31m%s\033[0m\n", result.Message)
 				} else {
-					uptimeKumaPushTotal.WithLabelValues("channel", "success").Inc()
+					log.Printf("\033[31m渠道 %s(ID:%d) 的模型 %s 测试失败，状态码：%d，响应：%s\033[0m\n", channel.Name, channel.ID, model, result.StatusCode, result.Message)
 				}
-			} else {
-				log.Printf("\033[31m渠道 %s(ID:%d) 的模型 %s 测试失败，状态码：%d，响应：%s\033[0m\n", channel.Name, channel.ID, model, resp.StatusCode, string(body))
 				modelTestTotal.WithLabelValues(
+					t.Name,
 					fmt.Sprintf("%d", channel.ID),
 					channel.Name,
 					model,
-					"failed",
+					status,
 				).Inc()
 				modelAvailability.WithLabelValues(
+					t.Name,
 					fmt.Sprintf("%d", channel.ID),
 					channel.Name,
 					model,
@@ -460,231 +468,262 @@ func testModels(channel Channel, wg *sync.WaitGroup, mu *sync.Mutex) {
 
 	// 更新可用模型数量指标
 	availableModelsGauge.WithLabelValues(
+		t.Name,
 		fmt.Sprintf("%d", channel.ID),
 		channel.Name,
 	).Set(float64(len(availableModels)))
-	
+
 	// 记录渠道测试完成
 	if len(availableModels) > 0 {
 		channelTestTotal.WithLabelValues(
+			t.Name,
 			fmt.Sprintf("%d", channel.ID),
 			channel.Name,
 			"success",
 		).Inc()
 	} else {
 		channelTestTotal.WithLabelValues(
+			t.Name,
 			fmt.Sprintf("%d", channel.ID),
 			channel.Name,
 			"failed",
 		).Inc()
 	}
 
-	// 更新模型
-	if config.DoNotModifyDb {
-		log.Println("跳过数据库更新")
-		return
-	}
-	mu.Lock()
-	err := updateModels(channel.ID, availableModels, channel.ModelMapping)
-	mu.Unlock()
-	if err != nil {
-		log.Printf("\033[31m更新渠道 %s(ID:%d) 的模型失败：%v\033[0m\n", channel.Name, channel.ID, err)
-		dbOperationTotal.WithLabelValues("update_models", "error").Inc()
-	} else {
-		log.Printf("渠道 %s(ID:%d) 可用模型：%v\n", channel.Name, channel.ID, availableModels)
-		dbOperationTotal.WithLabelValues("update_models", "success").Inc()
+	result.Skipped = false
+	result.Tested = len(modelList)
+	result.Available = availableModels
+	result.ModelMapping = modelMapping
+}
+
+// 所有渠道测试完成后统一更新，blocked为true时只计算变更不写入
+func (t *Target) updateChannels(results []ChannelResult, blocked bool) {
+	t.updateMu.Lock()
+	defer t.updateMu.Unlock()
+
+	for _, r := range results {
+		if r.Skipped {
+			continue
+		}
+		channel := r.Channel
+		err := t.updateModels(channel, r.Available, r.ModelMapping, r.Failures, blocked)
+		if err != nil {
+			log.Printf("\033[31m更新渠道 %s(ID:%d) 的模型失败：%v\033[0m\n", channel.Name, channel.ID, err)
+			dbOperationTotal.WithLabelValues(t.Name, "update_models", "error").Inc()
+		} else {
+			log.Printf("渠道 %s(ID:%d) 可用模型：%v\n", channel.Name, channel.ID, r.Available)
+			dbOperationTotal.WithLabelValues(t.Name, "update_models", "success").Inc()
+		}
 	}
+	t.flushCacheReload()
 }
 
-func updateModels(channelID int, models []string, modelMapping map[string]string) error {
+func (t *Target) updateModels(channel Channel, models []string, modelMapping map[string]string, failures map[string]string, blocked bool) error {
 	startTime := time.Now()
 	defer func() {
-		dbOperationDuration.WithLabelValues("update_models").Observe(time.Since(startTime).Seconds())
+		dbOperationDuration.WithLabelValues(t.Name, "update_models").Observe(time.Since(startTime).Seconds())
 	}()
 
-	// 获取旧的模型列表
-	var oldModels string
-	if err := db.Raw("SELECT models FROM channels WHERE id = ?", channelID).Scan(&oldModels).Error; err != nil {
+	protected := t.Config().protectedModels(channel.ID)
+	plan, err := t.planChannel(channel, models, modelMapping, protected)
+	if err != nil {
 		return err
 	}
-	oldModelsList := strings.Split(oldModels, ",")
-
-	// 如果不是onehub，直接更新数据库
-	if config.OneAPIType != "onehub" {
-		// 开始事务
-		tx := db.Begin()
-		if tx.Error != nil {
-			return tx.Error
-		}
-
-		// 处理模型映射，用modelMapping反向替换models中的模型
-		invertedMapping := make(map[string]string)
-		for k, v := range modelMapping {
-			invertedMapping[v] = k
-		}
-		for i, model := range models {
-			if v, ok := invertedMapping[model]; ok {
-				models[i] = v
-			}
-		}
-
-		// 更新channels表
-		modelsStr := strings.Join(models, ",")
-		query := "UPDATE channels SET models = ? WHERE id = ?"
-		result := tx.Exec(query, modelsStr, channelID)
-		if result.Error != nil {
-			tx.Rollback()
-			return result.Error
-		}
-
-		// 如果有名为refresh的渠道，删除
-		query = "DELETE FROM channels WHERE name = 'refresh'"
-		result = tx.Exec(query)
-		if result.Error != nil {
-			tx.Rollback()
-			return result.Error
+	// 记录被移除及受保护而保留的模型的失败原因
+	failedModels := append(plan.RemovedModels, plan.Protected...)
+	if plan.EmptyPolicy != "" {
+		failedModels = plan.OldModels
+	}
+	for _, model := range failedModels {
+		plan.Reasons[model] = ErrorClassNotListed
+	}
+	for model, class := range failures {
+		if _, ok := plan.Reasons[publicModelName(modelMapping, model)]; ok {
+			plan.Reasons[publicModelName(modelMapping, model)] = class
 		}
+	}
+	plan.CycleID = t.addChannelPlan(plan)
 
-		// 更新abilities表
-		// 硬删除
-		query = "DELETE FROM abilities WHERE channel_id = ? AND model NOT IN (?)"
-		result = tx.Exec(query, channelID, models)
-		if result.Error != nil {
-			tx.Rollback()
-			return result.Error
-		}
-		// 修改
-		query = "UPDATE abilities SET enabled = 1 WHERE channel_id = ? AND model IN (?)"
-		result = tx.Exec(query, channelID, models)
-		if result.Error != nil {
-			tx.Rollback()
-			return result.Error
+	for _, model := range protected {
+		value := 0.0
+		if containsString(plan.Protected, model) {
+			value = 1
 		}
+		modelProtectedFailure.WithLabelValues(
+			t.Name,
+			fmt.Sprintf("%d", channel.ID),
+			channel.Name,
+			model,
+		).Set(value)
+	}
 
-		// 提交事务
-		if err := tx.Commit().Error; err != nil {
-			tx.Rollback()
+	if blocked {
+		// 疑似监控端故障，已统一告警，不再逐个渠道通知
+		return nil
+	}
+	if t.Config().DoNotModifyDb {
+		log.Printf("演练模式，跳过渠道 %s(ID:%d) 的数据库更新\n", channel.Name, channel.ID)
+	} else if plan.hasChanges() {
+		if err := t.applyChannelPlan(plan); err != nil {
 			return err
 		}
-	} else {
-		// 如果是onehub，使用PUT更新
-		// 先获取渠道详情
-		url := config.BaseURL + "/api/channel/" + fmt.Sprintf("%d", channelID)
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return fmt.Errorf("创建请求失败：%v", err)
-		}
-		req.Header.Set("Authorization", "Bearer "+config.SystemToken)
+		t.recordHistory(plan)
+	}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			return fmt.Errorf("获取渠道详情失败：%v", err)
+	// 对比模型变化并发送通知，持续按empty_policy处理或保留受保护模型时不重复通知
+	findingsChanged := t.trackFindings(plan)
+	if len(plan.AddedModels) > 0 || len(plan.RemovedModels) > 0 || plan.statusChanged() || findingsChanged {
+		change := ChannelChange{
+			Target:          t.Name,
+			ChannelID:       plan.ChannelID,
+			ChannelName:     plan.ChannelName,
+			OldModels:       plan.OldModels,
+			NewModels:       plan.NewModels,
+			AddedModels:     plan.AddedModels,
+			RemovedModels:   plan.RemovedModels,
+			ProtectedModels: plan.Protected,
+			OldStatus:       plan.OldStatus,
+			NewStatus:       plan.NewStatus,
+			EmptyPolicy:     plan.EmptyPolicy,
+			DryRun:          t.Config().DoNotModifyDb,
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("获取渠道详情失败，状态码：%d", resp.StatusCode)
+		if err := sendNotification(change); err != nil {
+			log.Printf("发送通知失败: %v", err)
+			notificationTotal.WithLabelValues(t.Name, "model_change", "error").Inc()
+		} else {
+			notificationTotal.WithLabelValues(t.Name, "model_change", "success").Inc()
 		}
+	}
+	return nil
+}
 
-		body, _ := ioutil.ReadAll(resp.Body)
-		var response struct {
-			Data struct {
-				ID                 int                    `json:"id"`
-				Type               int                    `json:"type"`
-				Key                string                 `json:"key"`
-				Status             int                    `json:"status"`
-				Name               string                 `json:"name"`
-				Weight             int                    `json:"weight"`
-				CreatedTime        int                    `json:"created_time"`
-				TestTime           int                    `json:"test_time"`
-				ResponseTime       int                    `json:"response_time"`
-				BaseURL            string                 `json:"base_url"`
-				Other              string                 `json:"other"`
-				Balance            int                    `json:"balance"`
-				BalanceUpdatedTime int                    `json:"balance_updated_time"`
-				Models             string                 `json:"models"`
-				Group              string                 `json:"group"`
-				Tag                string                 `json:"tag"`
-				UsedQuota          int                    `json:"used_quota"`
-				ModelMapping       string                 `json:"model_mapping"`
-				ModelHeaders       string                 `json:"model_headers"`
-				Priority           int                    `json:"priority"`
-				Proxy              string                 `json:"proxy"`
-				TestModel          string                 `json:"test_model"`
-				OnlyChat           bool                   `json:"only_chat"`
-				PreCost            int                    `json:"pre_cost"`
-				Plugin             map[string]interface{} `json:"plugin"`
-			} `json:"data"`
-			Message string `json:"message"`
-			Success bool   `json:"success"`
-		}
+// 记录渠道本周期按empty_policy处理和因受保护而保留的模型，与上一周期不同时返回true
+func (t *Target) trackFindings(plan ChannelPlan) bool {
+	protected := append([]string(nil), plan.Protected...)
+	sort.Strings(protected)
+	findings := ""
+	if plan.EmptyPolicy != "" || len(protected) > 0 {
+		findings = plan.EmptyPolicy + "|" + strings.Join(protected, ",")
+	}
 
-		if err := json.Unmarshal(body, &response); err != nil {
-			return fmt.Errorf("解析渠道详情失败：%v", err)
-		}
+	t.findingsMu.Lock()
+	defer t.findingsMu.Unlock()
+	if findings == t.findings[plan.ChannelID] {
+		return false
+	}
+	if findings == "" {
+		delete(t.findings, plan.ChannelID)
+	} else {
+		t.findings[plan.ChannelID] = findings
+	}
+	return findings != ""
+}
 
-		// 更新模型
-		response.Data.Models = strings.Join(models, ",")
+// 计算渠道需要的变更，不写入数据库
+// protected中的模型即使测试失败也会保留
+func (t *Target) planChannel(channel Channel, models []string, modelMapping map[string]string, protected []string) (ChannelPlan, error) {
+	// 获取旧的模型列表和状态
+	state, err := t.Backend().ReadChannel(channel.ID)
+	if err != nil {
+		return ChannelPlan{}, err
+	}
+	oldModelsList := state.Models
+	status := state.Status
 
-		// 更新渠道
-		url = config.BaseURL + "/api/channel/"
-		payloadBytes, _ := json.Marshal(response.Data)
-		req, err = http.NewRequest("PUT", url, strings.NewReader(string(payloadBytes)))
-		if err != nil {
-			return fmt.Errorf("创建请求失败：%v", err)
+	// 处理模型映射，用modelMapping反向替换models中的模型
+	invertedMapping := make(map[string]string)
+	for k, v := range modelMapping {
+		invertedMapping[v] = k
+	}
+	newModels := make([]string, len(models))
+	for i, model := range models {
+		if v, ok := invertedMapping[model]; ok {
+			newModels[i] = v
+		} else {
+			newModels[i] = model
 		}
-		req.Header.Set("Authorization", "Bearer "+config.SystemToken)
-		req.Header.Set("Content-Type", "application/json")
+	}
 
-		resp, err = client.Do(req)
-		if err != nil {
-			return fmt.Errorf("更新渠道失败：%v", err)
+	// 受保护的模型不会被自动移除
+	var kept []string
+	for _, model := range oldModelsList {
+		if containsString(protected, model) && !containsString(newModels, model) {
+			newModels = append(newModels, model)
+			kept = append(kept, model)
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("更新渠道失败，状态码：%d", resp.StatusCode)
-		}
-		log.Println("更新成功")
 	}
 
-	// 对比模型变化并发送通知
-	added, removed := compareModels(oldModelsList, models)
-	if len(added) > 0 || len(removed) > 0 {
-		var channelName string
-		if err := db.Raw("SELECT name FROM channels WHERE id = ?", channelID).Scan(&channelName).Error; err != nil {
-			log.Printf("获取渠道名称失败: %v", err)
+	// 没有任何模型通过时按empty_policy处理
+	newStatus := status
+	var emptyPolicy string
+	if len(newModels) == 0 && len(oldModelsList) > 0 {
+		emptyPolicy = t.Config().EmptyPolicy
+		switch emptyPolicy {
+		case EmptyPolicyKeep:
+			newModels = oldModelsList
+		case EmptyPolicyDisable:
+			newModels = oldModelsList
+			if status == ChannelStatusEnabled {
+				newStatus = ChannelStatusAutoDisabled
+			}
 		}
+	} else if len(newModels) > 0 && status == ChannelStatusAutoDisabled && t.Config().EmptyPolicy == EmptyPolicyDisable && t.disabledByEmptyPolicy(channel.ID) {
+		// 之前因没有可用模型被本监控禁用的渠道恢复后重新启用，其他原因禁用的渠道不处理
+		newStatus = ChannelStatusEnabled
+	}
 
-		change := ChannelChange{
-			ChannelID:     channelID,
-			ChannelName:   channelName,
-			OldModels:     oldModelsList,
-			NewModels:     models,
-			AddedModels:   added,
-			RemovedModels: removed,
-		}
+	added, removed := compareModels(oldModelsList, newModels)
+	plan := ChannelPlan{
+		ChannelID:     channel.ID,
+		ChannelName:   channel.Name,
+		OldModels:     oldModelsList,
+		NewModels:     newModels,
+		AddedModels:   added,
+		RemovedModels: removed,
+		Protected:     kept,
+		Reasons:       make(map[string]string),
+		OldStatus:     status,
+		NewStatus:     newStatus,
+		EmptyPolicy:   emptyPolicy,
+	}
 
-		if err := sendNotification(change); err != nil {
-			log.Printf("发送通知失败: %v", err)
-			notificationTotal.WithLabelValues("model_change", "error").Inc()
+	abilities, err := t.Backend().PlanAbilities(channel.ID, newModels, newStatus)
+	if err != nil {
+		return ChannelPlan{}, err
+	}
+	plan.Abilities = abilities
+	return plan, nil
+}
+
+func (t *Target) applyChannelPlan(plan ChannelPlan) error {
+	if err := t.Backend().Apply(plan); err != nil {
+		return err
+	}
+	t.markCacheDirty(plan.ChannelID)
+	if plan.statusChanged() {
+		t.emptyMu.Lock()
+		if plan.NewStatus == ChannelStatusAutoDisabled && plan.EmptyPolicy == EmptyPolicyDisable {
+			t.emptyDisabled[plan.ChannelID] = true
 		} else {
-			notificationTotal.WithLabelValues("model_change", "success").Inc()
+			delete(t.emptyDisabled, plan.ChannelID)
 		}
+		t.emptyMu.Unlock()
 	}
 	return nil
 }
 
-func pushModelUptime(modelName string) error {
-	if config.UptimeKuma.Status != "enabled" {
+func (t *Target) pushModelUptime(modelName string) error {
+	uptimeKuma := t.Config().UptimeKuma
+	if uptimeKuma.Status != "enabled" {
 		return nil
 	}
 
-	if config.UptimeKuma.ModelURL == nil {
+	if uptimeKuma.ModelURL == nil {
 		return nil
 	}
 
-	pushURL, ok := config.UptimeKuma.ModelURL[modelName]
+	pushURL, ok := uptimeKuma.ModelURL[modelName]
 	if !ok {
 		return fmt.Errorf("找不到模型 %s 的推送地址", modelName)
 	}
@@ -708,16 +747,17 @@ func pushModelUptime(modelName string) error {
 	return nil
 }
 
-func pushChannelUptime(channelID int) error {
-	if config.UptimeKuma.Status != "enabled" {
+func (t *Target) pushChannelUptime(channelID int) error {
+	uptimeKuma := t.Config().UptimeKuma
+	if uptimeKuma.Status != "enabled" {
 		return nil
 	}
 
-	if config.UptimeKuma.ChannelURL == nil {
+	if uptimeKuma.ChannelURL == nil {
 		return nil
 	}
 
-	pushURL, ok := config.UptimeKuma.ChannelURL[fmt.Sprintf("%d", channelID)]
+	pushURL, ok := uptimeKuma.ChannelURL[fmt.Sprintf("%d", channelID)]
 	if !ok {
 		return fmt.Errorf("找不到渠道 %d 的推送地址", channelID)
 	}
@@ -743,18 +783,32 @@ func pushChannelUptime(channelID int) error {
 
 // 启动Metrics服务器
 func startMetricsServer() {
+	cfg := currentConfig()
 	metricsPort := ":2112" // 默认端口
-	if config.MetricsPort != "" {
-		metricsPort = config.MetricsPort
+	if cfg.MetricsPort != "" {
+		metricsPort = cfg.MetricsPort
 	}
-	
+
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/api/plan", handlePlan)
+	mux.HandleFunc("/api/history", handleHistory)
+	mux.HandleFunc("/api/rollback", handleRollback)
+	mux.HandleFunc("/api/channels", handleChannels)
+	mux.HandleFunc("/api/channels/", handleChannels)
+	mux.HandleFunc("/api/models", handleModels)
+	mux.HandleFunc("/api/models/", handleModels)
+	mux.HandleFunc("/api/control/run", handleControlRun)
+	mux.HandleFunc("/api/control/test", handleControlTest)
+	mux.HandleFunc("/api/control/pause", handleControlPause)
+	mux.HandleFunc("/api/control/resume", handleControlPause)
+	mux.HandleFunc("/api/control/jobs/", handleControlJob)
+	mux.HandleFunc("/api/control/reload", handleControlReload)
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
-	
+
 	log.Printf("Starting metrics server on %s", metricsPort)
 	if err := http.ListenAndServe(metricsPort, mux); err != nil {
 		log.Printf("Failed to start metrics server: %v", err)
@@ -762,58 +816,5 @@ func startMetricsServer() {
 }
 
 func main() {
-	var err error
-	config, err = loadConfig()
-	if err != nil {
-		log.Fatal("加载配置失败：", err)
-	}
-
-	// 启动Metrics服务器
-	go startMetricsServer()
-
-	// 解析时间周期
-	duration, err := time.ParseDuration(config.TimePeriod)
-	if err != nil {
-		log.Fatal("解析时间周期失败：", err)
-	}
-
-	db, err = NewDB(*config)
-
-	if err != nil {
-		log.Fatal("数据库连接失败：", err)
-	}
-
-	ticker := time.NewTicker(duration)
-	defer ticker.Stop()
-
-	for {
-		cycleStart := time.Now()
-		log.Println("开始检测...")
-		
-		channels, err := fetchChannels()
-		if err != nil {
-			log.Printf("\033[31m获取渠道失败：%v\033[0m\n", err)
-			continue
-		}
-
-		var wg sync.WaitGroup
-		var mu sync.Mutex
-		for _, channel := range channels {
-			if channel.Name == "refresh" {
-				continue
-			}
-			wg.Add(1)
-			go testModels(channel, &wg, &mu)
-		}
-		wg.Wait()
-
-		// 记录测试周期指标
-		testCycleDuration.Observe(time.Since(cycleStart).Seconds())
-		testCycleTotal.Inc()
-		
-		log.Printf("测试周期完成，耗时：%v\n", time.Since(cycleStart))
-
-		// 等待下一个周期
-		<-ticker.C
-	}
-}
\ No newline at end of file
+	os.Exit(runCLI(os.Args[1:]))
+}
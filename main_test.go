@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// 模拟渠道上游：/v1/models按密钥返回模型列表，chat/completions只有passing中的模型返回200
+func newTestUpstream(t *testing.T, models map[string][]string, passing map[string]bool) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/models", func(w http.ResponseWriter, r *http.Request) {
+		var response struct {
+			Data []map[string]string `json:"data"`
+		}
+		for _, model := range models[r.Header.Get("Authorization")] {
+			response.Data = append(response.Data, map[string]string{"id": model})
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+	mux.HandleFunc("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		if !passing[request.Model] {
+			http.Error(w, `{"error":"upstream failed"}`, http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"Hi"}}]}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func sortedModels(s string) []string {
+	models := splitList(s)
+	sort.Strings(models)
+	return models
+}
+
+// 完整的检测周期：从SQLite读取渠道，直接探测上游，写回channels和abilities并记录历史
+func TestRunCycleWritesChannelsAndAbilities(t *testing.T) {
+	upstream := newTestUpstream(t,
+		map[string][]string{
+			"Bearer sk-1": {"gpt-4o", "gpt-4o-mini", "o1", "o3"},
+			"Bearer sk-2": {"claude-3-5-sonnet"},
+		},
+		map[string]bool{"gpt-4o": true, "o3": true},
+	)
+	cfg := Config{
+		OneAPIType:      BackendOneAPI,
+		ProtectedModels: []string{"o1"},
+		EmptyPolicy:     EmptyPolicyDisable,
+		History:         HistoryConfig{Enabled: true},
+	}
+	target, db := newTestTarget(t, cfg, oneAPISchema,
+		fmt.Sprintf("INSERT INTO channels (id, type, `key`, status, name, base_url, models, `group`, priority) VALUES (1, 1, 'sk-1', 1, 'openai', '%s', 'gpt-4o,gpt-4o-mini,o1', 'default', 4)", upstream.URL),
+		fmt.Sprintf("INSERT INTO channels (id, type, `key`, status, name, base_url, models, `group`, priority) VALUES (2, 1, 'sk-2', 1, 'claude', '%s', 'claude-3-5-sonnet', 'default', 0)", upstream.URL),
+		"INSERT INTO abilities VALUES ('default', 'gpt-4o', 1, 1, 9), ('default', 'gpt-4o-mini', 1, 1, 4), ('default', 'o1', 1, 1, 4), ('default', 'claude-3-5-sonnet', 2, 1, 0)",
+	)
+
+	report := target.runCycle()
+	if report.Error != "" || report.Blocked || len(report.Channels) != 2 {
+		t.Fatalf("检测周期异常: %+v", report)
+	}
+
+	// 渠道1：gpt-4o-mini失败被移除，o1失败但受保护，o3通过后新增
+	var channel ChannelRecord
+	if err := db.Where("id = ?", 1).Take(&channel).Error; err != nil {
+		t.Fatalf("读取渠道1失败: %v", err)
+	}
+	if got, want := sortedModels(channel.Models), []string{"gpt-4o", "o1", "o3"}; !reflect.DeepEqual(got, want) || channel.Status != ChannelStatusEnabled {
+		t.Errorf("渠道1的模型为 %v、状态为 %d，期望 %v 且启用", got, channel.Status, want)
+	}
+	abilities := readAbilities(t, db, 1)
+	if a := abilities["default/gpt-4o"]; !a.Enabled || a.Priority != 9 {
+		t.Errorf("通过的模型应保留原有priority: %+v", a)
+	}
+	if a := abilities["default/gpt-4o-mini"]; a.Enabled {
+		t.Errorf("移除的模型应被禁用: %+v", a)
+	}
+	if a := abilities["default/o1"]; !a.Enabled {
+		t.Errorf("受保护的模型应保持启用: %+v", a)
+	}
+	if a, ok := abilities["default/o3"]; !ok || !a.Enabled || a.Priority != 4 {
+		t.Errorf("新增的模型应按渠道的priority插入: %+v", a)
+	}
+
+	// 渠道2：没有模型通过，按empty_policy保留模型并禁用
+	channel = ChannelRecord{}
+	if err := db.Where("id = ?", 2).Take(&channel).Error; err != nil {
+		t.Fatalf("读取渠道2失败: %v", err)
+	}
+	if channel.Models != "claude-3-5-sonnet" || channel.Status != ChannelStatusAutoDisabled {
+		t.Errorf("渠道2的模型为 %s、状态为 %d，期望保留模型并自动禁用", channel.Models, channel.Status)
+	}
+	if a := readAbilities(t, db, 2)["default/claude-3-5-sonnet"]; a.Enabled {
+		t.Errorf("禁用渠道的abilities应被禁用: %+v", a)
+	}
+
+	// 历史记录中的失败原因
+	records, err := target.listHistory(0, "", 0)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("listHistory 返回 %d 条记录, %v", len(records), err)
+	}
+	for _, record := range records {
+		var reasons map[string]string
+		json.Unmarshal([]byte(record.Reasons), &reasons)
+		switch record.ChannelID {
+		case 1:
+			if reasons["gpt-4o-mini"] != ErrorClassServer || reasons["o1"] != ErrorClassServer {
+				t.Errorf("渠道1的失败原因为 %v", reasons)
+			}
+		case 2:
+			if record.EmptyPolicy != EmptyPolicyDisable || reasons["claude-3-5-sonnet"] != ErrorClassServer {
+				t.Errorf("渠道2的记录为 %+v", record)
+			}
+		}
+	}
+}
+
+func TestTrackFindingsOnlyReportsChanges(t *testing.T) {
+	target := &Target{findings: make(map[int]string)}
+	empty := ChannelPlan{ChannelID: 1, EmptyPolicy: EmptyPolicyKeep}
+	protected := ChannelPlan{ChannelID: 1, Protected: []string{"gpt-4o"}}
+
+	steps := []struct {
+		plan ChannelPlan
+		want bool
+	}{
+		{empty, true},                      // 首次按empty_policy处理
+		{empty, false},                     // 持续无可用模型
+		{protected, true},                  // 变为只有受保护的模型失败
+		{protected, false},                 // 受保护的模型持续失败
+		{ChannelPlan{ChannelID: 1}, false}, // 恢复
+		{empty, true},                      // 再次无可用模型
+	}
+	for i, step := range steps {
+		if got := target.trackFindings(step.plan); got != step.want {
+			t.Errorf("第%d步 trackFindings 返回 %v，期望 %v", i+1, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+// 通过GORM模型访问OneAPI的表，由GORM按数据库类型处理key、group等保留字的引用，
+// 以及布尔值和IN列表的写法，保证MySQL、PostgreSQL、SQLite和SQL Server上行为一致
+
+// ChannelRecord channels表中监控用到的字段
+type ChannelRecord struct {
+	ID           int     `gorm:"column:id;primaryKey"`
+	Type         int     `gorm:"column:type"`
+	Name         string  `gorm:"column:name"`
+	BaseURL      *string `gorm:"column:base_url"`
+	Key          string  `gorm:"column:key"`
+	Status       int     `gorm:"column:status"`
+	Models       string  `gorm:"column:models"`
+	Group        string  `gorm:"column:group"`
+	Priority     *int64  `gorm:"column:priority"`
+	ModelMapping *string `gorm:"column:model_mapping"`
+}
+
+func (ChannelRecord) TableName() string {
+	return "channels"
+}
+
+// AbilityRecord abilities表中监控用到的字段，其余字段（如NewAPI的tag、weight）保持不变
+type AbilityRecord struct {
+	Group     string `gorm:"column:group;primaryKey"`
+	Model     string `gorm:"column:model;primaryKey"`
+	ChannelID int    `gorm:"column:channel_id;primaryKey"`
+	Enabled   bool   `gorm:"column:enabled"`
+	Priority  *int64 `gorm:"column:priority"`
+}
+
+func (AbilityRecord) TableName() string {
+	return "abilities"
+}
+
+// NewAPIChannelRecord NewAPI的channels表中新建abilities行时需要的额外字段
+type NewAPIChannelRecord struct {
+	ChannelRecord `gorm:"embedded"`
+	Weight        *uint   `gorm:"column:weight"`
+	Tag           *string `gorm:"column:tag"`
+}
+
+func (NewAPIChannelRecord) TableName() string {
+	return "channels"
+}
+
+// NewAPIAbilityRecord NewAPI的abilities表，新建行的tag和weight与渠道保持一致
+type NewAPIAbilityRecord struct {
+	AbilityRecord `gorm:"embedded"`
+	Weight        uint    `gorm:"column:weight"`
+	Tag           *string `gorm:"column:tag"`
+}
+
+func (NewAPIAbilityRecord) TableName() string {
+	return "abilities"
+}
+
+// 单行abilities的查询条件
+func abilityWhere(channelID int, group, model string) map[string]interface{} {
+	return map[string]interface{}{"channel_id": channelID, "group": group, "model": model}
+}
@@ -6,60 +6,117 @@ import (
 	"fmt"
 	"net/http"
 	"net/smtp"
-    "time"
+	"time"
 )
 
 type ChannelChange struct {
-	ChannelID     int      `json:"channel_id"`
-	ChannelName   string   `json:"channel_name"`
-	OldModels     []string `json:"old_models"`
-	NewModels     []string `json:"new_models"`
-	AddedModels   []string `json:"added_models"`
-	RemovedModels []string `json:"removed_models"`
+	Target          string   `json:"target"`
+	ChannelID       int      `json:"channel_id"`
+	ChannelName     string   `json:"channel_name"`
+	OldModels       []string `json:"old_models"`
+	NewModels       []string `json:"new_models"`
+	AddedModels     []string `json:"added_models"`
+	RemovedModels   []string `json:"removed_models"`
+	ProtectedModels []string `json:"protected_models"` // 测试失败但受保护而保留的模型
+	OldStatus       int      `json:"old_status"`
+	NewStatus       int      `json:"new_status"`
+	EmptyPolicy     string   `json:"empty_policy"` // 没有模型通过时采用的处理方式
+	DryRun          bool     `json:"dry_run"`
+}
+
+// 通知正文，演练模式下带有标记
+func formatChangeMessage(change ChannelChange) string {
+	msg := fmt.Sprintf(`
+网关: %s
+渠道ID: %d
+渠道名称: %s
+新增模型: %v
+移除模型: %v
+最新可用模型: %v
+`, change.Target, change.ChannelID, change.ChannelName, change.AddedModels, change.RemovedModels, change.NewModels)
+	if change.EmptyPolicy != "" {
+		msg += fmt.Sprintf("没有模型通过测试，处理方式: %s\n", change.EmptyPolicy)
+	}
+	if change.OldStatus != change.NewStatus {
+		msg += fmt.Sprintf("渠道状态: %d -> %d\n", change.OldStatus, change.NewStatus)
+	}
+	if len(change.ProtectedModels) > 0 {
+		msg += fmt.Sprintf("受保护未移除的失败模型: %v\n", change.ProtectedModels)
+	}
+	if change.DryRun {
+		msg = "\n[DRY RUN] 演练模式，以下变更未写入数据库" + msg
+	}
+	return msg
 }
 
 func sendNotification(change ChannelChange) error {
-    var e1, e2 error
+	var e1, e2 error
+	notification := currentConfig().Notification
 
-    if config.Notification.SMTP.Enabled {
-        if err := sendEmailNotification(change); err != nil {
-            e1 = fmt.Errorf("发送邮件通知失败: %v", err)
-        }
-    }
+	if notification.SMTP.Enabled {
+		if err := sendEmailNotification(change); err != nil {
+			e1 = fmt.Errorf("发送邮件通知失败: %v", err)
+		}
+	}
 
-    if config.Notification.Webhook.Enabled {
-        if err := sendWebhookNotification(change); err != nil {
-            e2 = fmt.Errorf("发送Webhook通知失败: %v", err)
-        }
-    }
+	if notification.Webhook.Enabled {
+		if err := sendWebhookNotification(change); err != nil {
+			e2 = fmt.Errorf("发送Webhook通知失败: %v", err)
+		}
+	}
 
-    if e1 != nil && e2 != nil {
-        return fmt.Errorf("%v\n%v", e1, e2)
-    }
+	if e1 != nil && e2 != nil {
+		return fmt.Errorf("%v\n%v", e1, e2)
+	}
 
-    if e1 != nil {
-        return e1
-    }
+	if e1 != nil {
+		return e1
+	}
 
-    if e2 != nil {
-        return e2
-    }
+	if e2 != nil {
+		return e2
+	}
 
-    return nil
+	return nil
 }
 
-func sendEmailNotification(change ChannelChange) error {
-	smtpConfig := config.Notification.SMTP
-	auth := smtp.PlainAuth("", smtpConfig.Username, smtpConfig.Password, smtpConfig.Host)
+// 发送与具体渠道无关的告警
+func sendAlert(subject, msg string) error {
+	var e1, e2 error
+	notification := currentConfig().Notification
+
+	if notification.SMTP.Enabled {
+		if err := sendEmail(subject, msg); err != nil {
+			e1 = fmt.Errorf("发送邮件通知失败: %v", err)
+		}
+	}
+
+	if notification.Webhook.Enabled && notification.Webhook.Type == "telegram" {
+		if err := sendTelegramNotification(msg); err != nil {
+			e2 = fmt.Errorf("发送Telegram通知失败: %v", err)
+		}
+	}
 
+	if e1 != nil && e2 != nil {
+		return fmt.Errorf("%v\n%v", e1, e2)
+	}
+	if e1 != nil {
+		return e1
+	}
+	return e2
+}
+
+func sendEmailNotification(change ChannelChange) error {
 	subject := "渠道模型变更通知"
-	body := fmt.Sprintf(`
-渠道ID: %d
-渠道名称: %s
-新增模型: %v
-移除模型: %v
-最新可用模型: %v
-`, change.ChannelID, change.ChannelName, change.AddedModels, change.RemovedModels, change.NewModels)
+	if change.DryRun {
+		subject = "[DRY RUN] " + subject
+	}
+	return sendEmail(subject, formatChangeMessage(change))
+}
+
+func sendEmail(subject, body string) error {
+	smtpConfig := currentConfig().Notification.SMTP
+	auth := smtp.PlainAuth("", smtpConfig.Username, smtpConfig.Password, smtpConfig.Host)
 
 	msg := fmt.Sprintf("From: %s\r\n"+
 		"To: %s\r\n"+
@@ -72,15 +129,9 @@ func sendEmailNotification(change ChannelChange) error {
 }
 
 func sendWebhookNotification(change ChannelChange) error {
-	msg := fmt.Sprintf(`
-渠道ID: %d
-渠道名称: %s
-新增模型: %v
-移除模型: %v
-最新可用模型: %v
-`, change.ChannelID, change.ChannelName, change.AddedModels, change.RemovedModels, change.NewModels)
+	msg := formatChangeMessage(change)
 
-	if config.Notification.Webhook.Type == "telegram" {
+	if currentConfig().Notification.Webhook.Type == "telegram" {
 		if err := sendTelegramNotification(msg); err != nil {
 			return fmt.Errorf("发送Telegram通知失败: %v", err)
 		}
@@ -90,8 +141,9 @@ func sendWebhookNotification(change ChannelChange) error {
 }
 
 func sendTelegramNotification(msg string) error {
-	telegramConfig := config.Notification.Webhook.Telegram
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", config.Notification.Webhook.Secret)
+	webhook := currentConfig().Notification.Webhook
+	telegramConfig := webhook.Telegram
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", webhook.Secret)
 
 	data := map[string]string{
 		"chat_id": telegramConfig.ChatID,
@@ -102,34 +154,34 @@ func sendTelegramNotification(msg string) error {
 	if err != nil {
 		return fmt.Errorf("序列化数据失败: %v", err)
 	}
-    // 重试逻辑
-    var lastErr error
-    for attempt := 1; attempt <= telegramConfig.Retry; attempt++ {
-        resp, err := http.Post(url, "application/json", bytes.NewReader(body))
-        if err != nil {
-            lastErr = fmt.Errorf("发送请求失败 (尝试 %d/%d): %v", 
-                attempt, telegramConfig.Retry, err)
-            if attempt < telegramConfig.Retry {
-                time.Sleep(time.Second * 2) // 重试前等待2秒
-                continue
-            }
-            return lastErr
-        }
-        defer resp.Body.Close()
-
-        if resp.StatusCode == http.StatusOK {
-            return nil
-        }
-        
-        lastErr = fmt.Errorf("请求失败 (尝试 %d/%d): %v", 
-            attempt, telegramConfig.Retry, resp.Status)
-        if attempt < telegramConfig.Retry {
-            time.Sleep(time.Second * 2)
-            continue
-        }
-    }
-
-    return lastErr
+	// 重试逻辑
+	var lastErr error
+	for attempt := 1; attempt <= telegramConfig.Retry; attempt++ {
+		resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			lastErr = fmt.Errorf("发送请求失败 (尝试 %d/%d): %v",
+				attempt, telegramConfig.Retry, err)
+			if attempt < telegramConfig.Retry {
+				time.Sleep(time.Second * 2) // 重试前等待2秒
+				continue
+			}
+			return lastErr
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+
+		lastErr = fmt.Errorf("请求失败 (尝试 %d/%d): %v",
+			attempt, telegramConfig.Retry, resp.Status)
+		if attempt < telegramConfig.Retry {
+			time.Sleep(time.Second * 2)
+			continue
+		}
+	}
+
+	return lastErr
 }
 
 func compareModels(old, new []string) ([]string, []string) {
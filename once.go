@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// RunOnceConfig once命令的退出码阈值，失败比例超过阈值时退出码为1
+type RunOnceConfig struct {
+	MaxFailedChannelRatio *float64 `json:"max_failed_channel_ratio" yaml:"max_failed_channel_ratio"` // 没有可用模型或被跳过的渠道比例，默认0，即任一渠道不可用就视为失败
+	MaxFailedModelRatio   *float64 `json:"max_failed_model_ratio" yaml:"max_failed_model_ratio"`     // 测试失败的模型比例，默认不检查
+}
+
+// OnceReport once命令输出的JSON报告
+type OnceReport struct {
+	Healthy            bool           `json:"healthy"`
+	ExitCode           int            `json:"exit_code"`
+	DryRun             bool           `json:"dry_run"`
+	StartedAt          time.Time      `json:"started_at"`
+	FinishedAt         time.Time      `json:"finished_at"`
+	TotalChannels      int            `json:"total_channels"`
+	FailedChannels     int            `json:"failed_channels"`
+	FailedChannelRatio float64        `json:"failed_channel_ratio"`
+	TotalModels        int            `json:"total_models"`
+	FailedModels       int            `json:"failed_models"`
+	FailedModelRatio   float64        `json:"failed_model_ratio"`
+	Problems           []string       `json:"problems"` // 导致失败的原因
+	Targets            []*CycleReport `json:"targets"`
+}
+
+// 汇总各网关的结果并按run_once的阈值判断是否健康
+func newOnceReport(reports []*CycleReport, thresholds RunOnceConfig, start time.Time, dryRun bool) *OnceReport {
+	report := &OnceReport{StartedAt: start, FinishedAt: time.Now(), DryRun: dryRun, Problems: []string{}, Targets: reports}
+	for _, r := range reports {
+		if r.Error != "" {
+			report.Problems = append(report.Problems, fmt.Sprintf("网关 %s 检测失败：%s", r.Target, r.Error))
+		}
+		if r.Blocked {
+			report.Problems = append(report.Problems, fmt.Sprintf("网关 %s 疑似监控端故障，变更未写入", r.Target))
+		}
+		for _, c := range r.Channels {
+			report.TotalChannels++
+			if c.Skipped || len(c.Available) == 0 {
+				report.FailedChannels++
+			}
+			report.TotalModels += c.Tested
+			report.FailedModels += len(c.Failures)
+		}
+	}
+	if report.TotalChannels > 0 {
+		report.FailedChannelRatio = float64(report.FailedChannels) / float64(report.TotalChannels)
+	}
+	if report.TotalModels > 0 {
+		report.FailedModelRatio = float64(report.FailedModels) / float64(report.TotalModels)
+	}
+
+	maxChannelRatio := 0.0
+	if thresholds.MaxFailedChannelRatio != nil {
+		maxChannelRatio = *thresholds.MaxFailedChannelRatio
+	}
+	if report.FailedChannelRatio > maxChannelRatio {
+		report.Problems = append(report.Problems, fmt.Sprintf("%d/%d 个渠道不可用，超过阈值 %.2f", report.FailedChannels, report.TotalChannels, maxChannelRatio))
+	}
+	if thresholds.MaxFailedModelRatio != nil && report.FailedModelRatio > *thresholds.MaxFailedModelRatio {
+		report.Problems = append(report.Problems, fmt.Sprintf("%d/%d 个模型测试失败，超过阈值 %.2f", report.FailedModels, report.TotalModels, *thresholds.MaxFailedModelRatio))
+	}
+
+	report.Healthy = len(report.Problems) == 0
+	report.ExitCode = exitOK
+	for _, r := range reports {
+		if r.Error != "" {
+			report.ExitCode = exitError
+		}
+	}
+	if report.ExitCode == exitOK && !report.Healthy {
+		report.ExitCode = exitUnhealthy
+	}
+	return report
+}
+
+func writeOnceReport(report *OnceReport, path string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func validateRunOnce(thresholds RunOnceConfig) error {
+	for name, ratio := range map[string]*float64{
+		"max_failed_channel_ratio": thresholds.MaxFailedChannelRatio,
+		"max_failed_model_ratio":   thresholds.MaxFailedModelRatio,
+	} {
+		if ratio != nil && (*ratio < 0 || *ratio > 1) {
+			return fmt.Errorf("run_once的%s需要在0到1之间", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AbilityChange abilities表中单行的变更
+type AbilityChange struct {
+	Group  string      `json:"group"`
+	Model  string      `json:"model"`
+	Action string      `json:"action"`           // insert | enable | disable | delete | restore
+	Before *AbilityRow `json:"before,omitempty"` // 变更前的行，用于回滚；restore时按它重新插入
+}
+
+// AbilityRow abilities表中单行的内容
+type AbilityRow struct {
+	Enabled  bool    `json:"enabled"`
+	Priority *int64  `json:"priority,omitempty"`
+	Weight   *uint   `json:"weight,omitempty"` // 仅NewAPI
+	Tag      *string `json:"tag,omitempty"`    // 仅NewAPI
+}
+
+// ChannelPlan 单个渠道在本周期内的全部变更
+type ChannelPlan struct {
+	ChannelID     int               `json:"channel_id"`
+	ChannelName   string            `json:"channel_name"`
+	OldModels     []string          `json:"old_models"`
+	NewModels     []string          `json:"new_models"`
+	AddedModels   []string          `json:"added_models"`
+	RemovedModels []string          `json:"removed_models"`
+	Abilities     []AbilityChange   `json:"abilities"`
+	Protected     []string          `json:"protected,omitempty"` // 测试失败但受保护而保留的模型
+	Reasons       map[string]string `json:"reasons,omitempty"`   // 被移除或受保护而保留的模型 -> 失败原因分类
+	OldStatus     int               `json:"old_status"`
+	NewStatus     int               `json:"new_status"`
+	EmptyPolicy   string            `json:"empty_policy,omitempty"` // 没有模型通过时采用的处理方式
+	CycleID       string            `json:"cycle_id"`
+}
+
+// CyclePlan 一个检测周期的变更计划
+type CyclePlan struct {
+	ID         string        `json:"id"`
+	Target     string        `json:"target"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	DryRun     bool          `json:"dry_run"`
+	Blocked    string        `json:"blocked,omitempty"` // 被故障保护阻止写入的原因
+	Channels   []ChannelPlan `json:"channels"`
+}
+
+func (p ChannelPlan) modelsChanged() bool {
+	return strings.Join(p.OldModels, ",") != strings.Join(p.NewModels, ",")
+}
+
+func (p ChannelPlan) statusChanged() bool {
+	return p.OldStatus != p.NewStatus
+}
+
+func (p ChannelPlan) hasChanges() bool {
+	return p.modelsChanged() || p.statusChanged() || len(p.Abilities) > 0
+}
+
+func (p ChannelPlan) hasFindings() bool {
+	return p.hasChanges() || len(p.Protected) > 0 || p.EmptyPolicy != ""
+}
+
+// 周期ID为秒级时间加随机后缀，同一秒内的手动测试和检测周期不会共用ID
+func newPlanID(prefix string) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return prefix + time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// 开始记录新周期的变更计划
+func (t *Target) beginPlan() {
+	t.planMu.Lock()
+	defer t.planMu.Unlock()
+	t.currentPlan = &CyclePlan{ID: newPlanID(""), Target: t.Name, StartedAt: time.Now(), DryRun: t.Config().DoNotModifyDb}
+}
+
+// 将渠道变更加入本周期的计划，返回周期ID
+func (t *Target) addChannelPlan(plan ChannelPlan) string {
+	t.planMu.Lock()
+	defer t.planMu.Unlock()
+	if t.currentPlan == nil {
+		return ""
+	}
+	plan.CycleID = t.currentPlan.ID
+	t.currentPlan.Channels = append(t.currentPlan.Channels, plan)
+	return t.currentPlan.ID
+}
+
+// 标记本周期的变更被故障保护阻止
+func (t *Target) blockPlan(reason string) {
+	t.planMu.Lock()
+	defer t.planMu.Unlock()
+	if t.currentPlan != nil {
+		t.currentPlan.Blocked = reason
+	}
+}
+
+// 结束本周期，输出可读的变更列表，并按配置写入JSON文件
+func (t *Target) finishPlan() {
+	t.planMu.Lock()
+	plan := t.currentPlan
+	t.currentPlan = nil
+	if plan != nil {
+		plan.FinishedAt = time.Now()
+		t.lastPlan = plan
+	}
+	t.planMu.Unlock()
+	if plan == nil {
+		return
+	}
+
+	if plan.Blocked != "" {
+		log.Printf("网关 %s 疑似监控端故障，以下变更未写入数据库：\n%s", t.Name, formatPlan(plan))
+	} else if plan.DryRun {
+		log.Printf("网关 %s 演练模式，以下变更未写入数据库：\n%s", t.Name, formatPlan(plan))
+	} else {
+		log.Printf("网关 %s 本周期变更：\n%s", t.Name, formatPlan(plan))
+	}
+
+	if planFile := t.Config().PlanFile; planFile != "" {
+		data, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			log.Printf("序列化变更计划失败：%v\n", err)
+			return
+		}
+		if err := ioutil.WriteFile(planFile, data, 0644); err != nil {
+			log.Printf("写入变更计划文件 %s 失败：%v\n", planFile, err)
+		}
+	}
+}
+
+func formatPlan(plan *CyclePlan) string {
+	var b strings.Builder
+	changed := 0
+	for _, c := range plan.Channels {
+		if !c.hasFindings() {
+			continue
+		}
+		changed++
+		fmt.Fprintf(&b, "渠道 %s(ID:%d)\n", c.ChannelName, c.ChannelID)
+		if c.EmptyPolicy != "" {
+			fmt.Fprintf(&b, "  没有模型通过测试，按 %s 处理\n", c.EmptyPolicy)
+		}
+		if c.statusChanged() {
+			fmt.Fprintf(&b, "  status %d -> %d\n", c.OldStatus, c.NewStatus)
+		}
+		for _, model := range c.AddedModels {
+			fmt.Fprintf(&b, "  + %s\n", model)
+		}
+		for _, model := range c.RemovedModels {
+			if reason, ok := c.Reasons[model]; ok {
+				fmt.Fprintf(&b, "  - %s (%s)\n", model, reason)
+			} else {
+				fmt.Fprintf(&b, "  - %s\n", model)
+			}
+		}
+		for _, model := range c.Protected {
+			fmt.Fprintf(&b, "  ! %s (protected, %s)\n", model, c.Reasons[model])
+		}
+		for _, a := range c.Abilities {
+			fmt.Fprintf(&b, "  abilities %s %s/%s\n", a.Action, a.Group, a.Model)
+		}
+	}
+	if changed == 0 {
+		b.WriteString("无变更\n")
+	}
+	return b.String()
+}
+
+// 返回最近一个周期的变更计划
+func handlePlan(w http.ResponseWriter, r *http.Request) {
+	t, ok := targetFromRequest(w, r)
+	if !ok {
+		return
+	}
+	t.planMu.Lock()
+	plan := t.lastPlan
+	t.planMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if plan == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"暂无变更计划"}`))
+		return
+	}
+	json.NewEncoder(w).Encode(plan)
+}
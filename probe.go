@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 探测方式
+const (
+	ProbeDirect  = "direct"  // 直接以OpenAI格式请求渠道上游
+	ProbeGateway = "gateway" // 调用网关自带的渠道测试接口 /api/channel/test/:id
+)
+
+type ProbeConfig struct {
+	Default     string            `json:"default" yaml:"default"`
+	ChannelType map[string]string `json:"channel_type" yaml:"channel_type"`
+}
+
+// ProbeResult 单次模型探测的结果
+type ProbeResult struct {
+	Success    bool
+	StatusCode int
+	Latency    float64 // 秒
+	Message    string
+}
+
+// 失败原因分类
+const (
+	ErrorClassNetwork     = "network_error"  // 未收到响应
+	ErrorClassAuth        = "auth_error"     // 401/403
+	ErrorClassNotFound    = "not_found"      // 404
+	ErrorClassRateLimited = "rate_limited"   // 429
+	ErrorClassClient      = "client_error"   // 其他4xx
+	ErrorClassServer      = "server_error"   // 5xx
+	ErrorClassGateway     = "gateway_failed" // 网关测试接口返回失败
+	ErrorClassNotListed   = "not_listed"     // 模型未出现在本次测试的模型列表中
+)
+
+// ErrorClass 返回失败原因的分类，成功时返回空字符串
+func (r ProbeResult) ErrorClass() string {
+	switch {
+	case r.Success:
+		return ""
+	case r.StatusCode == 0:
+		return ErrorClassNetwork
+	case r.StatusCode == http.StatusUnauthorized || r.StatusCode == http.StatusForbidden:
+		return ErrorClassAuth
+	case r.StatusCode == http.StatusNotFound:
+		return ErrorClassNotFound
+	case r.StatusCode == http.StatusTooManyRequests:
+		return ErrorClassRateLimited
+	case r.StatusCode >= 500:
+		return ErrorClassServer
+	case r.StatusCode >= 400:
+		return ErrorClassClient
+	default:
+		return ErrorClassGateway
+	}
+}
+
+// 根据渠道类型选择探测方式
+func (t *Target) probeModeFor(channel Channel) string {
+	if mode, ok := t.Config().Probe.ChannelType[fmt.Sprintf("%d", channel.Type)]; ok {
+		return mode
+	}
+	return t.Config().Probe.Default
+}
+
+func (t *Target) probeModel(channel Channel, model string) ProbeResult {
+	if t.probeModeFor(channel) == ProbeGateway {
+		return t.probeGateway(channel, model)
+	}
+	return t.probeDirect(channel, model)
+}
+
+// 直接向渠道上游发送一次最小的 chat/completions 请求
+func (t *Target) probeDirect(channel Channel, model string) ProbeResult {
+	reqURL := channel.BaseURL
+	if !strings.Contains(channel.BaseURL, "/v1/chat/completions") {
+		if !strings.HasSuffix(channel.BaseURL, "/chat") {
+			if !strings.HasSuffix(channel.BaseURL, "/v1") {
+				reqURL += "/v1"
+			}
+			reqURL += "/chat"
+		}
+		reqURL += "/completions"
+	}
+
+	// 构造请求
+	reqBody := map[string]interface{}{
+		"model": model,
+		"messages": []map[string]string{
+			{"role": "user", "content": "Hi"},
+		},
+		"max_tokens": 1,
+	}
+	jsonData, _ := json.Marshal(reqBody)
+
+	req, err := http.NewRequest("POST", reqURL, strings.NewReader(string(jsonData)))
+	if err != nil {
+		return ProbeResult{Message: fmt.Sprintf("创建请求失败：%v", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+channel.Key)
+
+	// 记录响应时间
+	startTime := time.Now()
+	client := &http.Client{Timeout: time.Duration(t.Config().Timeout) * time.Second}
+	resp, err := client.Do(req)
+	responseTime := time.Since(startTime).Seconds()
+	if err != nil {
+		return ProbeResult{Latency: responseTime, Message: fmt.Sprintf("请求失败：%v", err)}
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	return ProbeResult{
+		Success:    resp.StatusCode == http.StatusOK,
+		StatusCode: resp.StatusCode,
+		Latency:    responseTime,
+		Message:    string(body),
+	}
+}
+
+// 调用网关的渠道测试接口，由网关自身的适配器完成请求，
+// 可以覆盖Claude、Gemini、百度、阿里等非OpenAI格式的渠道
+func (t *Target) probeGateway(channel Channel, model string) ProbeResult {
+	req, err := newAdminRequest(t.Config(), "GET", fmt.Sprintf("/api/channel/test/%d?model=%s", channel.ID, url.QueryEscape(model)), nil)
+	if err != nil {
+		return ProbeResult{Message: fmt.Sprintf("创建请求失败：%v", err)}
+	}
+
+	startTime := time.Now()
+	client := &http.Client{Timeout: time.Duration(t.Config().Timeout) * time.Second}
+	resp, err := client.Do(req)
+	responseTime := time.Since(startTime).Seconds()
+	if err != nil {
+		return ProbeResult{Latency: responseTime, Message: fmt.Sprintf("请求失败：%v", err)}
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return ProbeResult{StatusCode: resp.StatusCode, Latency: responseTime, Message: string(body)}
+	}
+
+	var response struct {
+		Success bool    `json:"success"`
+		Message string  `json:"message"`
+		Time    float64 `json:"time"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return ProbeResult{StatusCode: resp.StatusCode, Latency: responseTime, Message: fmt.Sprintf("解析测试结果失败：%v", err)}
+	}
+
+	// 优先使用网关返回的耗时，它不包含监控到网关之间的开销
+	if response.Time > 0 {
+		responseTime = response.Time
+	}
+	return ProbeResult{
+		Success:    response.Success,
+		StatusCode: resp.StatusCode,
+		Latency:    responseTime,
+		Message:    response.Message,
+	}
+}
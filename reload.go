@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 整个进程共用的配置项，其余配置项属于各网关
+var processConfigKeys = []string{
+	"metrics_port", "metrics_enabled", "push_gateway", "notification", "control_token",
+	"result_store", "status_page.enabled", "status_page.listen", "status_page.title", "run_once", "config_watch_interval",
+}
+
+// 只在启动时读取、重新加载后不会生效的配置项
+var (
+	restartProcessKeys = []string{"metrics_port", "metrics_enabled", "push_gateway", "result_store", "status_page.enabled", "status_page.listen"}
+	restartTargetKeys  = []string{"db_type", "db_dsn", "history"}
+)
+
+// 日志中不显示这些配置项的值
+var secretConfigWords = []string{"token", "password", "secret", "dsn", "key", "url"}
+
+// ReloadResult 一次重新加载的结果
+type ReloadResult struct {
+	Changes         []string `json:"changes"`
+	RestartRequired []string `json:"restart_required"` // 需要重启才能生效，本次未应用
+}
+
+var reloadMu sync.Mutex
+
+// 重新读取配置文件，全部校验通过后才替换配置。
+// 各网关的配置在当前检测周期结束后替换，time_period变化时重新计时
+func reloadConfig() (*ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	config := currentConfig()
+	newConfig, err := loadConfig(config.path)
+	if err != nil {
+		return nil, err
+	}
+	result := &ReloadResult{Changes: []string{}, RestartRequired: []string{}}
+
+	oldFlat, newFlat := flattenConfig(config), flattenConfig(newConfig)
+	for _, key := range diffKeys(oldFlat, newFlat) {
+		if !isProcessKey(key) {
+			continue
+		}
+		if matchKey(key, restartProcessKeys) {
+			result.RestartRequired = append(result.RestartRequired, key)
+		} else {
+			result.Changes = append(result.Changes, describeChange(key, oldFlat[key], newFlat[key]))
+		}
+	}
+	// 保留只在启动时读取的配置，使配置与实际运行的状态一致
+	newConfig.MetricsPort = config.MetricsPort
+	newConfig.MetricsEnabled = config.MetricsEnabled
+	newConfig.PushGateway = config.PushGateway
+	newConfig.ResultStore = config.ResultStore
+	newConfig.StatusPage.Enabled = config.StatusPage.Enabled
+	newConfig.StatusPage.Listen = config.StatusPage.Listen
+
+	// 先为每个网关准备好新的配置和后端，任何一个失败都不替换
+	type pending struct {
+		target  *Target
+		cfg     *Config
+		backend Backend
+	}
+	var updates []pending
+	seen := make(map[string]bool)
+	for _, cfg := range newConfig.targets {
+		seen[cfg.Name] = true
+		t, err := findTarget(cfg.Name)
+		if err != nil {
+			result.RestartRequired = append(result.RestartRequired, "targets."+cfg.Name)
+			continue
+		}
+		if _, err := time.ParseDuration(cfg.TimePeriod); err != nil {
+			return nil, fmt.Errorf("网关 %s 解析时间周期失败：%v", cfg.Name, err)
+		}
+
+		if t.dryRunForced {
+			// 启动时识别网关类型失败或与配置不一致，保持只读
+			cfg.DoNotModifyDb = true
+		}
+
+		oldFlat, newFlat := flattenConfig(t.Config()), flattenConfig(cfg)
+		for _, key := range diffKeys(oldFlat, newFlat) {
+			if isProcessKey(key) || key == "targets" || strings.HasPrefix(key, "targets.") {
+				continue
+			}
+			prefix := ""
+			if len(targets) > 1 {
+				prefix = "targets." + t.Name + "."
+			}
+			if matchKey(key, restartTargetKeys) {
+				result.RestartRequired = append(result.RestartRequired, prefix+key)
+			} else if key != "oneapi_type" || cfg.OneAPIType != BackendAuto {
+				result.Changes = append(result.Changes, describeChange(prefix+key, oldFlat[key], newFlat[key]))
+			}
+		}
+		cfg.DbType = t.Config().DbType
+		cfg.DbDsn = t.Config().DbDsn
+		cfg.History = t.Config().History
+		if cfg.OneAPIType == BackendAuto {
+			// 沿用启动时识别到的类型
+			cfg.OneAPIType = t.Config().OneAPIType
+		}
+
+		backend, err := newBackend(cfg, t.DB)
+		if err != nil {
+			return nil, fmt.Errorf("网关 %s 初始化失败：%v", cfg.Name, err)
+		}
+		updates = append(updates, pending{target: t, cfg: cfg, backend: backend})
+	}
+	for _, t := range targets {
+		if !seen[t.Name] {
+			result.RestartRequired = append(result.RestartRequired, "targets."+t.Name)
+		}
+	}
+
+	// 替换指针而不修改旧配置，正在读取旧配置的代码不受影响
+	setConfig(newConfig)
+	for _, u := range updates {
+		u.target.applyConfig(u.cfg, u.backend)
+	}
+
+	for _, change := range result.Changes {
+		log.Printf("配置变更：%s\n", change)
+	}
+	if len(result.RestartRequired) > 0 {
+		log.Printf("\033[33m以下配置需要重启后生效：%s\033[0m\n", strings.Join(result.RestartRequired, ", "))
+	}
+	log.Printf("配置已重新加载，%d 项变更\n", len(result.Changes))
+	return result, nil
+}
+
+// 等待正在进行的检测周期结束后替换网关的配置
+func (t *Target) applyConfig(cfg *Config, backend Backend) {
+	t.cycleMu.Lock()
+	old := t.Config()
+	t.setSettings(cfg, backend)
+	t.cycleMu.Unlock()
+
+	if cfg.MaxConcurrent != old.MaxConcurrent || cfg.RPS != old.RPS {
+		t.limitMu.Lock()
+		t.limits = make(map[int]*channelLimit)
+		t.limitMu.Unlock()
+	}
+	if cfg.TimePeriod != old.TimePeriod {
+		d, _ := time.ParseDuration(cfg.TimePeriod)
+		// 只保留最新的周期
+		select {
+		case <-t.reschedule:
+		default:
+		}
+		t.reschedule <- d
+	}
+}
+
+// 将配置展开为 路径 -> JSON值，数组作为一个整体比较
+func flattenConfig(c *Config) map[string]string {
+	data, _ := json.Marshal(c)
+	var tree map[string]interface{}
+	json.Unmarshal(data, &tree)
+	flat := make(map[string]string)
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+			for k, child := range m {
+				walk(prefix+k+".", child)
+			}
+			return
+		}
+		value, _ := json.Marshal(v)
+		flat[strings.TrimSuffix(prefix, ".")] = string(value)
+	}
+	walk("", tree)
+	return flat
+}
+
+func diffKeys(old, new map[string]string) []string {
+	var keys []string
+	for k, v := range new {
+		if old[k] != v {
+			keys = append(keys, k)
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// key等于list中的某一项或在其之下
+func matchKey(key string, list []string) bool {
+	for _, item := range list {
+		if key == item || strings.HasPrefix(key, item+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func isProcessKey(key string) bool {
+	return matchKey(key, processConfigKeys)
+}
+
+func describeChange(key, old, new string) string {
+	lower := strings.ToLower(key)
+	for _, word := range secretConfigWords {
+		if strings.Contains(lower, word) {
+			return key + " 已修改"
+		}
+	}
+	if old == "" {
+		old = "(无)"
+	}
+	if new == "" {
+		new = "(无)"
+	}
+	return fmt.Sprintf("%s: %s -> %s", key, old, new)
+}
+
+// 按config_watch_interval检查配置文件的修改时间，修改后重新加载
+func watchConfigFile() {
+	path := currentConfig().path
+	lastStat, _ := os.Stat(path)
+	for {
+		interval, _ := time.ParseDuration(currentConfig().ConfigWatchInterval)
+		if interval <= 0 {
+			// 已关闭检查，通过SIGHUP或控制接口重新加载后可能再次开启
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		time.Sleep(interval)
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if lastStat != nil && stat.ModTime().Equal(lastStat.ModTime()) && stat.Size() == lastStat.Size() {
+			continue
+		}
+		lastStat = stat
+		log.Printf("配置文件 %s 已修改，重新加载\n", path)
+		if _, err := reloadConfig(); err != nil {
+			log.Printf("\033[31m重新加载配置失败，继续使用当前配置：%v\033[0m\n", err)
+		}
+	}
+}
+
+// 收到SIGHUP时重新加载配置
+func handleReloadSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		log.Println("收到SIGHUP，重新加载配置")
+		if _, err := reloadConfig(); err != nil {
+			log.Printf("\033[31m重新加载配置失败，继续使用当前配置：%v\033[0m\n", err)
+		}
+	}
+}
+
+// POST /api/control/reload 重新加载配置文件
+func handleControlReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeControl(w, r) {
+		return
+	}
+	result, err := reloadConfig()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 路由建议写入的位置
+const (
+	RoutingModeChannel = "channel" // 写入 channels.priority / channels.weight
+	RoutingModeAbility = "ability" // 按模型写入 abilities.priority
+)
+
+type RoutingAdvisorConfig struct {
+	Enabled     bool   `json:"enabled" yaml:"enabled"`
+	Mode        string `json:"mode" yaml:"mode"`
+	Window      string `json:"window" yaml:"window"`
+	MinSamples  int    `json:"min_samples" yaml:"min_samples"`
+	MinPriority int64  `json:"min_priority" yaml:"min_priority"`
+	MaxPriority int64  `json:"max_priority" yaml:"max_priority"`
+	MinWeight   int    `json:"min_weight" yaml:"min_weight"`
+	MaxWeight   int    `json:"max_weight" yaml:"max_weight"`
+}
+
+type probeSample struct {
+	Time    time.Time
+	Success bool
+	Latency float64
+}
+
+type routingKey struct {
+	ChannelID int
+	Model     string
+}
+
+// routingStats 单个(渠道, 模型)在统计窗口内的表现
+type routingStats struct {
+	Samples     int
+	SuccessRate float64
+	P95Latency  float64
+}
+
+// 记录一次探测结果，模型名需为网关对外的模型名
+func (t *Target) recordRoutingSample(channelID int, model string, result ProbeResult) {
+	if !t.Config().RoutingAdvisor.Enabled {
+		return
+	}
+	t.routingMu.Lock()
+	defer t.routingMu.Unlock()
+	key := routingKey{ChannelID: channelID, Model: model}
+	t.routingSamples[key] = append(t.routingSamples[key], probeSample{
+		Time:    time.Now(),
+		Success: result.Success,
+		Latency: result.Latency,
+	})
+}
+
+// 将上游模型名还原为网关对外的模型名
+func publicModelName(modelMapping map[string]string, model string) string {
+	for k, v := range modelMapping {
+		if v == model {
+			return k
+		}
+	}
+	return model
+}
+
+// 丢弃窗口之外的样本并汇总
+func (t *Target) collectRoutingStats(window time.Duration) map[routingKey][]probeSample {
+	t.routingMu.Lock()
+	defer t.routingMu.Unlock()
+	cutoff := time.Now().Add(-window)
+	snapshot := make(map[routingKey][]probeSample)
+	for key, samples := range t.routingSamples {
+		kept := samples[:0]
+		for _, s := range samples {
+			if s.Time.After(cutoff) {
+				kept = append(kept, s)
+			}
+		}
+		if len(kept) == 0 {
+			delete(t.routingSamples, key)
+			continue
+		}
+		t.routingSamples[key] = kept
+		snapshot[key] = append([]probeSample(nil), kept...)
+	}
+	return snapshot
+}
+
+func summarizeSamples(samples []probeSample) routingStats {
+	var latencies []float64
+	success := 0
+	for _, s := range samples {
+		if s.Success {
+			success++
+			latencies = append(latencies, s.Latency)
+		}
+	}
+	stats := routingStats{Samples: len(samples)}
+	if len(samples) > 0 {
+		stats.SuccessRate = float64(success) / float64(len(samples))
+	}
+	if len(latencies) > 0 {
+		sort.Float64s(latencies)
+		idx := int(math.Ceil(0.95*float64(len(latencies)))) - 1
+		stats.P95Latency = latencies[idx]
+	}
+	return stats
+}
+
+// 综合成功率与相对延迟得到 [0, 1] 的分数，bestP95为同组中最低的P95延迟
+func routingScore(stats routingStats, bestP95 float64) float64 {
+	if stats.SuccessRate == 0 || stats.P95Latency <= 0 {
+		return 0
+	}
+	return stats.SuccessRate * (bestP95 / stats.P95Latency)
+}
+
+func scaleInt64(score float64, min, max int64) int64 {
+	return min + int64(math.Round(score*float64(max-min)))
+}
+
+// 根据窗口内的探测结果调整渠道或模型的优先级与权重
+func (t *Target) applyRoutingAdvice() {
+	advisor := t.Config().RoutingAdvisor
+	if !advisor.Enabled {
+		return
+	}
+	window, err := time.ParseDuration(advisor.Window)
+	if err != nil {
+		log.Printf("解析路由建议统计窗口失败：%v\n", err)
+		return
+	}
+
+	// 通过管理接口读写的网关不会从数据库重新加载优先级和权重
+	b, ok := t.Backend().(*sqlBackend)
+	if !ok {
+		log.Printf("\033[31m网关类型为%s，routing_advisor只支持直接读写oneapi或newapi的数据库\033[0m\n", t.Config().OneAPIType)
+		return
+	}
+
+	snapshot := t.collectRoutingStats(window)
+	if advisor.Mode == RoutingModeAbility {
+		t.applyAbilityRouting(b.db, snapshot)
+	} else {
+		t.applyChannelRouting(b.db, snapshot)
+	}
+	t.flushCacheReload()
+}
+
+func (t *Target) applyAbilityRouting(db *gorm.DB, snapshot map[routingKey][]probeSample) {
+	advisor := t.Config().RoutingAdvisor
+
+	// 同一模型的渠道之间比较延迟
+	byModel := make(map[string]map[int]routingStats)
+	for key, samples := range snapshot {
+		stats := summarizeSamples(samples)
+		if stats.Samples < advisor.MinSamples {
+			continue
+		}
+		if byModel[key.Model] == nil {
+			byModel[key.Model] = make(map[int]routingStats)
+		}
+		byModel[key.Model][key.ChannelID] = stats
+	}
+
+	for model, channels := range byModel {
+		bestP95 := bestLatency(channels)
+		for channelID, stats := range channels {
+			priority := scaleInt64(routingScore(stats, bestP95), advisor.MinPriority, advisor.MaxPriority)
+			startTime := time.Now()
+			err := db.Model(&AbilityRecord{}).Where("channel_id = ? AND model = ?", channelID, model).Update("priority", priority).Error
+			dbOperationDuration.WithLabelValues(t.Name, "update_routing").Observe(time.Since(startTime).Seconds())
+			if err != nil {
+				dbOperationTotal.WithLabelValues(t.Name, "update_routing", "error").Inc()
+				log.Printf("\033[31m更新渠道 %d 模型 %s 的优先级失败：%v\033[0m\n", channelID, model, err)
+				continue
+			}
+			dbOperationTotal.WithLabelValues(t.Name, "update_routing", "success").Inc()
+			t.markCacheDirty(channelID)
+			log.Printf("渠道 %d 模型 %s：成功率 %.2f，P95延迟 %.2fs，优先级设为 %d\n", channelID, model, stats.SuccessRate, stats.P95Latency, priority)
+		}
+	}
+}
+
+func (t *Target) applyChannelRouting(db *gorm.DB, snapshot map[routingKey][]probeSample) {
+	advisor := t.Config().RoutingAdvisor
+
+	// 按渠道合并所有模型的样本
+	merged := make(map[int][]probeSample)
+	for key, samples := range snapshot {
+		merged[key.ChannelID] = append(merged[key.ChannelID], samples...)
+	}
+	channels := make(map[int]routingStats)
+	for channelID, samples := range merged {
+		stats := summarizeSamples(samples)
+		if stats.Samples < advisor.MinSamples {
+			continue
+		}
+		channels[channelID] = stats
+	}
+
+	bestP95 := bestLatency(channels)
+	for channelID, stats := range channels {
+		score := routingScore(stats, bestP95)
+		priority := scaleInt64(score, advisor.MinPriority, advisor.MaxPriority)
+		weight := int(scaleInt64(score, int64(advisor.MinWeight), int64(advisor.MaxWeight)))
+
+		startTime := time.Now()
+		updates := map[string]interface{}{"priority": priority, "weight": weight}
+		err := db.Model(&ChannelRecord{}).Where("id = ?", channelID).Updates(updates).Error
+		dbOperationDuration.WithLabelValues(t.Name, "update_routing").Observe(time.Since(startTime).Seconds())
+		if err != nil {
+			dbOperationTotal.WithLabelValues(t.Name, "update_routing", "error").Inc()
+			log.Printf("\033[31m更新渠道 %d 的优先级和权重失败：%v\033[0m\n", channelID, err)
+			continue
+		}
+		dbOperationTotal.WithLabelValues(t.Name, "update_routing", "success").Inc()
+		t.markCacheDirty(channelID)
+		log.Printf("渠道 %d：成功率 %.2f，P95延迟 %.2fs，优先级设为 %d，权重设为 %d\n", channelID, stats.SuccessRate, stats.P95Latency, priority, weight)
+	}
+}
+
+func bestLatency(channels map[int]routingStats) float64 {
+	best := 0.0
+	for _, stats := range channels {
+		if stats.P95Latency > 0 && (best == 0 || stats.P95Latency < best) {
+			best = stats.P95Latency
+		}
+	}
+	return best
+}
+
+func validateRoutingAdvisor(advisor RoutingAdvisorConfig) error {
+	if advisor.Mode != RoutingModeChannel && advisor.Mode != RoutingModeAbility {
+		return fmt.Errorf("未知的路由建议模式: %s", advisor.Mode)
+	}
+	if _, err := time.ParseDuration(advisor.Window); err != nil {
+		return fmt.Errorf("解析路由建议统计窗口失败: %v", err)
+	}
+	if advisor.MinPriority > advisor.MaxPriority || advisor.MinWeight > advisor.MaxWeight {
+		return fmt.Errorf("路由建议的下限不能大于上限")
+	}
+	return nil
+}
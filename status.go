@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 状态接口中保存的失败响应长度
+const statusErrorLength = 256
+
+// healthStreak 连续保持同一状态的次数和最近一次状态变化
+type healthStreak struct {
+	Healthy    bool       `json:"healthy"`
+	Streak     int        `json:"streak"`                // 连续保持当前状态的次数
+	LastChange *time.Time `json:"last_change,omitempty"` // 最近一次状态变化的时间，启动后未变化过时为空
+}
+
+func (s *healthStreak) observe(healthy bool, now time.Time) {
+	if s.Streak > 0 && s.Healthy == healthy {
+		s.Streak++
+		return
+	}
+	if s.Streak > 0 {
+		s.LastChange = &now
+	}
+	s.Healthy = healthy
+	s.Streak = 1
+}
+
+// ModelHealth 渠道上一个模型最近一次的探测结果
+type ModelHealth struct {
+	Model string `json:"model"` // 网关对外的模型名
+	healthStreak
+	Latency     float64    `json:"latency"` // 秒
+	StatusCode  int        `json:"status_code"`
+	ErrorClass  string     `json:"error_class,omitempty"` // 本次失败的原因分类
+	LastError   string     `json:"last_error,omitempty"`  // 最近一次失败的响应
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	CheckedAt   time.Time  `json:"checked_at"`
+}
+
+// ChannelHealth 渠道最近一个周期的测试结果，至少一个模型可用时视为健康
+type ChannelHealth struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Type   int    `json:"type"`
+	Status int    `json:"status"` // 测试时网关中的渠道状态
+	healthStreak
+	Skipped     bool          `json:"skipped"` // 最近一个周期未能获取模型列表
+	Tested      int           `json:"tested"`
+	Available   int           `json:"available"`
+	Latency     float64       `json:"latency"`              // 可用模型的平均延迟
+	LastError   string        `json:"last_error,omitempty"` // 最近一次失败的模型和响应
+	LastErrorAt *time.Time    `json:"last_error_at,omitempty"`
+	CheckedAt   time.Time     `json:"checked_at"`
+	Models      []ModelHealth `json:"models,omitempty"` // 仅在/api/channels/{id}中返回
+}
+
+// ModelChannelHealth 模型在某个渠道上的结果
+type ModelChannelHealth struct {
+	ChannelID   int    `json:"channel_id"`
+	ChannelName string `json:"channel_name"`
+	ModelHealth
+}
+
+// ModelSummary 模型在所有渠道上的汇总，至少一个渠道可用时视为健康
+type ModelSummary struct {
+	Model string `json:"model"`
+	healthStreak
+	ChannelCount     int                  `json:"channel_count"`
+	HealthyChannels  int                  `json:"healthy_channels"`
+	Latency          float64              `json:"latency"` // 可用渠道的平均延迟
+	LastError        string               `json:"last_error,omitempty"`
+	LastErrorAt      *time.Time           `json:"last_error_at,omitempty"`
+	LastErrorChannel int                  `json:"last_error_channel,omitempty"`
+	CheckedAt        time.Time            `json:"checked_at"`
+	Channels         []ModelChannelHealth `json:"channels,omitempty"` // 仅在/api/models/{name}中返回
+}
+
+type channelState struct {
+	ChannelHealth
+	models map[string]*ModelHealth
+}
+
+// 记录一次探测结果，model为网关对外的模型名
+func (t *Target) recordModelStatus(channel Channel, model string, result ProbeResult) {
+	now := time.Now()
+	t.statusMu.Lock()
+	defer t.statusMu.Unlock()
+
+	cs, ok := t.channelStatus[channel.ID]
+	if !ok {
+		cs = &channelState{models: make(map[string]*ModelHealth)}
+		t.channelStatus[channel.ID] = cs
+	}
+	m, ok := cs.models[model]
+	if !ok {
+		m = &ModelHealth{Model: model}
+		cs.models[model] = m
+	}
+	m.observe(result.Success, now)
+	m.Latency = result.Latency
+	m.StatusCode = result.StatusCode
+	m.ErrorClass = result.ErrorClass()
+	m.CheckedAt = now
+	if !result.Success {
+		m.LastError = truncateSnippet(result.Message, statusErrorLength)
+		m.LastErrorAt = &now
+	}
+}
+
+// 测试结束后汇总各渠道和模型的状态，移除cycleStart之前未再测试的模型。
+// prune为true时为完整周期，同时移除本周期未出现的渠道；为false时只有实际测试过的模型的汇总计入连续次数
+func (t *Target) finishStatus(results []ChannelResult, cycleStart time.Time, prune bool) {
+	now := time.Now()
+	t.statusMu.Lock()
+	defer t.statusMu.Unlock()
+
+	seen := make(map[int]bool)
+	// 本次实际测试过的模型，手动测试时只有这些模型的汇总计入连续次数
+	tested := make(map[string]bool)
+	for _, result := range results {
+		channel := result.Channel
+		seen[channel.ID] = true
+		cs, ok := t.channelStatus[channel.ID]
+		if !ok {
+			cs = &channelState{models: make(map[string]*ModelHealth)}
+			t.channelStatus[channel.ID] = cs
+		}
+		for _, m := range cs.models {
+			if m.CheckedAt.After(cs.CheckedAt) {
+				tested[m.Model] = true
+			}
+		}
+		cs.ID = channel.ID
+		cs.Name = channel.Name
+		cs.Type = channel.Type
+		cs.Status = channel.Status
+		cs.Skipped = result.Skipped
+		cs.CheckedAt = now
+		if result.Skipped {
+			// 未能获取模型列表时保留上一次的结果
+			continue
+		}
+
+		cs.Available = 0
+		cs.Latency = 0
+		for name, m := range cs.models {
+			if m.CheckedAt.Before(cycleStart) {
+				delete(cs.models, name)
+				continue
+			}
+			if m.Healthy {
+				cs.Available++
+				cs.Latency += m.Latency
+			}
+			if m.LastErrorAt != nil && (cs.LastErrorAt == nil || m.LastErrorAt.After(*cs.LastErrorAt)) {
+				cs.LastError = m.Model + ": " + m.LastError
+				cs.LastErrorAt = m.LastErrorAt
+			}
+		}
+		if cs.Available > 0 {
+			cs.Latency /= float64(cs.Available)
+		}
+		cs.Tested = len(cs.models)
+		cs.observe(cs.Available > 0, now)
+	}
+	if prune {
+		for id := range t.channelStatus {
+			if !seen[id] {
+				delete(t.channelStatus, id)
+			}
+		}
+	}
+
+	// 按模型汇总所有渠道的结果
+	summaries := make(map[string]*ModelSummary)
+	for _, cs := range t.channelStatus {
+		for _, m := range cs.models {
+			s, ok := summaries[m.Model]
+			if !ok {
+				s = &ModelSummary{Model: m.Model}
+				summaries[m.Model] = s
+			}
+			s.ChannelCount++
+			if m.Healthy {
+				s.HealthyChannels++
+				s.Latency += m.Latency
+			}
+			if m.LastErrorAt != nil && (s.LastErrorAt == nil || m.LastErrorAt.After(*s.LastErrorAt)) {
+				s.LastError = m.LastError
+				s.LastErrorAt = m.LastErrorAt
+				s.LastErrorChannel = cs.ID
+			}
+		}
+	}
+	for name, s := range summaries {
+		if s.HealthyChannels > 0 {
+			s.Latency /= float64(s.HealthyChannels)
+		}
+		previous, ok := t.modelStatus[name]
+		if ok {
+			s.healthStreak = previous.healthStreak
+		}
+		if !prune && ok && !tested[name] {
+			// 手动测试没有涉及的模型保留上一次的状态
+			s.CheckedAt = previous.CheckedAt
+			continue
+		}
+		s.observe(s.HealthyChannels > 0, now)
+		s.CheckedAt = now
+	}
+	t.modelStatus = summaries
+}
+
+func (t *Target) listChannelHealth() []ChannelHealth {
+	t.statusMu.Lock()
+	defer t.statusMu.Unlock()
+	list := make([]ChannelHealth, 0, len(t.channelStatus))
+	for _, cs := range t.channelStatus {
+		// 只有探测结果、周期还未结束的渠道不返回
+		if cs.ID == 0 {
+			continue
+		}
+		list = append(list, cs.ChannelHealth)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func (t *Target) getChannelHealth(channelID int) (ChannelHealth, bool) {
+	t.statusMu.Lock()
+	defer t.statusMu.Unlock()
+	cs, ok := t.channelStatus[channelID]
+	if !ok || cs.ID == 0 {
+		return ChannelHealth{}, false
+	}
+	health := cs.ChannelHealth
+	health.Models = make([]ModelHealth, 0, len(cs.models))
+	for _, m := range cs.models {
+		health.Models = append(health.Models, *m)
+	}
+	sort.Slice(health.Models, func(i, j int) bool { return health.Models[i].Model < health.Models[j].Model })
+	return health, true
+}
+
+func (t *Target) listModelSummaries() []ModelSummary {
+	t.statusMu.Lock()
+	defer t.statusMu.Unlock()
+	list := make([]ModelSummary, 0, len(t.modelStatus))
+	for _, s := range t.modelStatus {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Model < list[j].Model })
+	return list
+}
+
+func (t *Target) getModelSummary(model string) (ModelSummary, bool) {
+	t.statusMu.Lock()
+	defer t.statusMu.Unlock()
+	s, ok := t.modelStatus[model]
+	if !ok {
+		return ModelSummary{}, false
+	}
+	summary := *s
+	for _, cs := range t.channelStatus {
+		if m, ok := cs.models[model]; ok && cs.ID != 0 {
+			summary.Channels = append(summary.Channels, ModelChannelHealth{ChannelID: cs.ID, ChannelName: cs.Name, ModelHealth: *m})
+		}
+	}
+	sort.Slice(summary.Channels, func(i, j int) bool { return summary.Channels[i].ChannelID < summary.Channels[j].ChannelID })
+	return summary, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// GET /api/channels?target=xxx 或 GET /api/channels/{id}?target=xxx
+func handleChannels(w http.ResponseWriter, r *http.Request) {
+	t, ok := targetFromRequest(w, r)
+	if !ok {
+		return
+	}
+	idText := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/channels"), "/")
+	if idText == "" {
+		writeJSON(w, http.StatusOK, t.listChannelHealth())
+		return
+	}
+	channelID, err := strconv.Atoi(idText)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "无效的渠道ID"})
+		return
+	}
+	health, ok := t.getChannelHealth(channelID)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "暂无该渠道的测试结果"})
+		return
+	}
+	writeJSON(w, http.StatusOK, health)
+}
+
+// GET /api/models?target=xxx 或 GET /api/models/{name}?target=xxx，模型名可以包含/
+func handleModels(w http.ResponseWriter, r *http.Request) {
+	t, ok := targetFromRequest(w, r)
+	if !ok {
+		return
+	}
+	model := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/models"), "/")
+	if model == "" {
+		writeJSON(w, http.StatusOK, t.listModelSummaries())
+		return
+	}
+	summary, ok := t.getModelSummary(model)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "暂无该模型的测试结果"})
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusPageConfig 内置状态页，enabled、listen和title整个进程共用，hidden_channels和channel_names可以按网关设置
+type StatusPageConfig struct {
+	Enabled        bool              `json:"enabled" yaml:"enabled"`
+	Listen         string            `json:"listen" yaml:"listen"`                   // 单独的监听地址，默认:2113，只提供状态页
+	Title          string            `json:"title" yaml:"title"`                     // 默认"服务状态"
+	HiddenChannels []int             `json:"hidden_channels" yaml:"hidden_channels"` // 不在状态页中出现的渠道，也不计入模型的可用率
+	ChannelNames   map[string]string `json:"channel_names" yaml:"channel_names"`     // 渠道ID -> 状态页中显示的名称
+}
+
+//go:embed statuspage.html
+var statusPageHTML string
+
+var statusPageTemplate = template.Must(template.New("status").Parse(statusPageHTML))
+
+// 状态页统计的时间范围，每个范围划分为固定数量的柱
+var statusRanges = []struct {
+	Name string
+	Bar  time.Duration
+	Bars int
+}{
+	{"24h", time.Hour, 24},
+	{"7d", 6 * time.Hour, 28},
+	{"30d", 24 * time.Hour, 30},
+}
+
+const (
+	statusHistoryHours = 30 * 24
+	statusCacheTTL     = time.Minute
+	statusMaxIncidents = 20
+)
+
+// hourBucket 一小时内的探测汇总
+type hourBucket struct {
+	Total      int
+	Successes  int
+	LatencySum float64
+}
+
+func (b *hourBucket) add(o hourBucket) {
+	b.Total += o.Total
+	b.Successes += o.Successes
+	b.LatencySum += o.LatencySum
+}
+
+func (b hourBucket) uptime() float64 {
+	if b.Total == 0 {
+		return 0
+	}
+	return float64(b.Successes) / float64(b.Total)
+}
+
+// statusHistory 按小时汇总的探测历史，下标0为最近一小时
+type statusHistory struct {
+	Now          time.Time
+	Models       map[string][]hourBucket
+	Channels     map[int][]hourBucket
+	ChannelNames map[int]string // 探测记录中的渠道名
+}
+
+func (h *statusHistory) add(channelID int, channelName, model string, at time.Time, b hourBucket) {
+	index := int(h.Now.Sub(at) / time.Hour)
+	if index < 0 || index >= statusHistoryHours {
+		return
+	}
+	if h.Models[model] == nil {
+		h.Models[model] = make([]hourBucket, statusHistoryHours)
+	}
+	if h.Channels[channelID] == nil {
+		h.Channels[channelID] = make([]hourBucket, statusHistoryHours)
+	}
+	h.Models[model][index].add(b)
+	h.Channels[channelID][index].add(b)
+	if channelName != "" {
+		h.ChannelNames[channelID] = channelName
+	}
+}
+
+type statusBar struct {
+	Class string // up、degraded、down或none
+	Title string
+}
+
+type statusRow struct {
+	Name      string
+	Healthy   bool
+	Known     bool // 最近一个周期测试过
+	Uptime    []string
+	Bars      []statusBar
+	Sparkline string // SVG折线的坐标
+}
+
+type statusIncident struct {
+	Model    string
+	Start    time.Time
+	Duration time.Duration
+	Ongoing  bool
+}
+
+type statusPageData struct {
+	Title     string
+	Target    string
+	Range     string
+	Ranges    []string
+	Models    []statusRow
+	Channels  []statusRow
+	Incidents []statusIncident
+	UpdatedAt time.Time
+}
+
+// probeHourRow 原始结果按渠道、模型和小时在数据库中汇总后的一行
+type probeHourRow struct {
+	ChannelID   int
+	ChannelName string
+	Model       string
+	ProbeHour   int64
+	Total       int
+	Successes   int
+	LatencySum  float64
+}
+
+// 读取最近30天的原始结果和汇总，隐藏的渠道不计入。
+// 原始结果在数据库中按小时分组汇总，不逐条读取
+func (t *Target) loadStatusHistory(now time.Time) (*statusHistory, error) {
+	h := &statusHistory{
+		Now:          now,
+		Models:       make(map[string][]hourBucket),
+		Channels:     make(map[int][]hourBucket),
+		ChannelNames: make(map[int]string),
+	}
+	since := now.Add(-statusHistoryHours * time.Hour)
+	hidden := t.Config().StatusPage.HiddenChannels
+
+	var rows []probeHourRow
+	err := resultStore.Model(&ProbeRecord{}).
+		Select("channel_id, channel_name, model, probe_hour, COUNT(*) AS total, "+
+			"SUM(CASE WHEN success = ? THEN 1 ELSE 0 END) AS successes, "+
+			"SUM(CASE WHEN success = ? THEN latency ELSE 0 END) AS latency_sum", true, true).
+		Where("target = ? AND probe_hour >= ?", t.Name, since.Unix()/3600).
+		Group("channel_id, channel_name, model, probe_hour").
+		Order("probe_hour").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		if contains(hidden, r.ChannelID) {
+			continue
+		}
+		at := time.Unix(r.ProbeHour*3600, 0)
+		h.add(r.ChannelID, r.ChannelName, r.Model, at, hourBucket{Total: r.Total, Successes: r.Successes, LatencySum: r.LatencySum})
+	}
+
+	var rollups []ProbeRollup
+	if err := resultStore.Where("target = ? AND bucket >= ?", t.Name, since).Find(&rollups).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rollups {
+		if contains(hidden, r.ChannelID) {
+			continue
+		}
+		h.add(r.ChannelID, "", r.Model, r.Bucket, hourBucket{Total: r.Total, Successes: r.Successes, LatencySum: r.LatencySum})
+	}
+	return h, nil
+}
+
+// 一分钟内重复访问时使用缓存的历史
+func (t *Target) cachedStatusHistory() (*statusHistory, error) {
+	t.pageMu.Lock()
+	defer t.pageMu.Unlock()
+	now := time.Now()
+	if t.pageHistory != nil && now.Sub(t.pageHistory.Now) < statusCacheTTL {
+		return t.pageHistory, nil
+	}
+	h, err := t.loadStatusHistory(now)
+	if err != nil {
+		return nil, err
+	}
+	t.pageHistory = h
+	return h, nil
+}
+
+// 渠道在状态页中显示的名称
+func (t *Target) statusChannelName(channelID int, recorded string) string {
+	if name, ok := t.Config().StatusPage.ChannelNames[strconv.Itoa(channelID)]; ok {
+		return name
+	}
+	if recorded != "" {
+		return recorded
+	}
+	return fmt.Sprintf("渠道 #%d", channelID)
+}
+
+func (t *Target) buildStatusPage(h *statusHistory, rangeName string) statusPageData {
+	data := statusPageData{
+		Title:     currentConfig().StatusPage.Title,
+		Target:    t.Name,
+		Range:     rangeName,
+		UpdatedAt: h.Now,
+	}
+	for _, r := range statusRanges {
+		data.Ranges = append(data.Ranges, r.Name)
+	}
+
+	// 缓存的历史可能被多个请求同时使用，不修改其中的数据
+	names := make(map[int]string)
+	for id, name := range h.ChannelNames {
+		names[id] = name
+	}
+
+	// 当前状态，隐藏的渠道不参与
+	hidden := t.Config().StatusPage.HiddenChannels
+	t.statusMu.Lock()
+	modelHealthy := make(map[string]bool)
+	channelHealthy := make(map[int]bool)
+	for id, cs := range t.channelStatus {
+		if cs.ID == 0 || contains(hidden, id) {
+			continue
+		}
+		channelHealthy[id] = cs.Healthy
+		names[id] = cs.Name
+		for _, m := range cs.models {
+			modelHealthy[m.Model] = modelHealthy[m.Model] || m.Healthy
+		}
+	}
+	t.statusMu.Unlock()
+
+	for model, hours := range h.Models {
+		healthy, known := modelHealthy[model]
+		data.Models = append(data.Models, newStatusRow(model, healthy, known, hours, rangeName))
+		data.Incidents = append(data.Incidents, findIncidents(model, hours, h.Now)...)
+	}
+	for model, healthy := range modelHealthy {
+		if h.Models[model] == nil {
+			data.Models = append(data.Models, newStatusRow(model, healthy, true, nil, rangeName))
+		}
+	}
+	sort.Slice(data.Models, func(i, j int) bool { return data.Models[i].Name < data.Models[j].Name })
+
+	var channelIDs []int
+	for id := range h.Channels {
+		channelIDs = append(channelIDs, id)
+	}
+	for id := range channelHealthy {
+		if h.Channels[id] == nil {
+			channelIDs = append(channelIDs, id)
+		}
+	}
+	sort.Ints(channelIDs)
+	for _, id := range channelIDs {
+		healthy, known := channelHealthy[id]
+		data.Channels = append(data.Channels, newStatusRow(t.statusChannelName(id, names[id]), healthy, known, h.Channels[id], rangeName))
+	}
+
+	sort.Slice(data.Incidents, func(i, j int) bool { return data.Incidents[i].Start.After(data.Incidents[j].Start) })
+	if len(data.Incidents) > statusMaxIncidents {
+		data.Incidents = data.Incidents[:statusMaxIncidents]
+	}
+	return data
+}
+
+func newStatusRow(name string, healthy, known bool, hours []hourBucket, rangeName string) statusRow {
+	row := statusRow{Name: name, Healthy: healthy, Known: known}
+	if hours == nil {
+		hours = make([]hourBucket, statusHistoryHours)
+	}
+	for _, r := range statusRanges {
+		var total hourBucket
+		for _, b := range hours[:r.Bars*int(r.Bar/time.Hour)] {
+			total.add(b)
+		}
+		if total.Total == 0 {
+			row.Uptime = append(row.Uptime, "-")
+		} else {
+			row.Uptime = append(row.Uptime, fmt.Sprintf("%.2f%%", total.uptime()*100))
+		}
+		if r.Name != rangeName {
+			continue
+		}
+
+		// 从最早到最近排列
+		perBar := int(r.Bar / time.Hour)
+		var latencies []float64
+		for i := r.Bars - 1; i >= 0; i-- {
+			var bar hourBucket
+			for _, b := range hours[i*perBar : (i+1)*perBar] {
+				bar.add(b)
+			}
+			row.Bars = append(row.Bars, newStatusBar(bar))
+			latency := 0.0
+			if bar.Successes > 0 {
+				latency = bar.LatencySum / float64(bar.Successes)
+			}
+			latencies = append(latencies, latency)
+		}
+		row.Sparkline = sparklinePoints(latencies)
+	}
+	return row
+}
+
+func newStatusBar(b hourBucket) statusBar {
+	if b.Total == 0 {
+		return statusBar{Class: "none", Title: "无数据"}
+	}
+	uptime := b.uptime()
+	class := "up"
+	switch {
+	case uptime < 0.5:
+		class = "down"
+	case uptime < 0.99:
+		class = "degraded"
+	}
+	return statusBar{Class: class, Title: fmt.Sprintf("可用率 %.2f%%，%d/%d", uptime*100, b.Successes, b.Total)}
+}
+
+// 延迟折线的坐标，画布为100x20，没有成功探测的点按0处理
+func sparklinePoints(latencies []float64) string {
+	max := 0.0
+	for _, l := range latencies {
+		if l > max {
+			max = l
+		}
+	}
+	if max == 0 || len(latencies) < 2 {
+		return ""
+	}
+	points := make([]string, len(latencies))
+	for i, l := range latencies {
+		x := float64(i) * 100 / float64(len(latencies)-1)
+		y := 20 - l/max*18 - 1
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	return strings.Join(points, " ")
+}
+
+// 大部分探测失败的小时视为故障，中间没有数据的小时不打断故障
+func findIncidents(model string, hours []hourBucket, now time.Time) []statusIncident {
+	var incidents []statusIncident
+	newest, oldest := -1, -1
+	recovered := false // 是否已经遇到比当前故障更晚的正常小时
+	flush := func() {
+		if newest < 0 {
+			return
+		}
+		incidents = append(incidents, statusIncident{
+			Model:    model,
+			Start:    now.Add(-time.Duration(oldest+1) * time.Hour),
+			Duration: time.Duration(oldest-newest+1) * time.Hour,
+			Ongoing:  !recovered,
+		})
+		newest, oldest = -1, -1
+	}
+	for i, b := range hours {
+		if b.Total == 0 {
+			continue
+		}
+		if b.uptime() < 0.5 {
+			if newest < 0 {
+				newest = i
+			}
+			oldest = i
+			continue
+		}
+		flush()
+		recovered = true
+	}
+	flush()
+	return incidents
+}
+
+// 故障时长，按小时统计
+func (i statusIncident) DurationText() string {
+	hours := int(i.Duration / time.Hour)
+	if hours < 24 {
+		return fmt.Sprintf("%d小时", hours)
+	}
+	return fmt.Sprintf("%d天%d小时", hours/24, hours%24)
+}
+
+// 状态页使用单独的监听地址，公开状态页不会同时暴露指标、状态接口和控制接口
+func startStatusPageServer() {
+	listen := currentConfig().StatusPage.Listen
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", handleStatusPage)
+
+	log.Printf("Starting status page server on %s", listen)
+	if err := http.ListenAndServe(listen, mux); err != nil {
+		log.Printf("Failed to start status page server: %v", err)
+	}
+}
+
+// GET /status?target=xxx&range=24h|7d|30d
+func handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	t, ok := targetFromRequest(w, r)
+	if !ok {
+		return
+	}
+	rangeName := r.URL.Query().Get("range")
+	valid := false
+	for _, sr := range statusRanges {
+		valid = valid || sr.Name == rangeName
+	}
+	if !valid {
+		rangeName = statusRanges[0].Name
+	}
+
+	h, err := t.cachedStatusHistory()
+	if err != nil {
+		log.Printf("\033[31m读取探测历史失败：%v\033[0m\n", err)
+		http.Error(w, "读取探测历史失败", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusPageTemplate.Execute(w, t.buildStatusPage(h, rangeName)); err != nil {
+		log.Printf("\033[31m渲染状态页失败：%v\033[0m\n", err)
+	}
+}
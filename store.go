@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+type ResultStoreConfig struct {
+	Enabled            bool   `json:"enabled" yaml:"enabled"`
+	DbType             string `json:"db_type" yaml:"db_type"`                         // 默认sqlite
+	DbDsn              string `json:"db_dsn" yaml:"db_dsn"`                           // 默认channel_monitor.db
+	RawRetention       string `json:"raw_retention" yaml:"raw_retention"`             // 原始结果保留时长，之后汇总，默认168h
+	Retention          string `json:"retention" yaml:"retention"`                     // 汇总结果保留时长，默认2160h
+	DownsampleInterval string `json:"downsample_interval" yaml:"downsample_interval"` // 汇总的时间粒度，默认1h
+	SnippetLength      *int   `json:"snippet_length" yaml:"snippet_length"`           // 保存的响应片段长度，默认256，0表示不保存
+}
+
+// ProbeRecord 单次模型探测的结果
+type ProbeRecord struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Target      string    `gorm:"size:64;index:idx_probe_lookup" json:"target"`
+	ChannelID   int       `gorm:"index:idx_probe_lookup" json:"channel_id"`
+	ChannelName string    `gorm:"size:255" json:"channel_name"`
+	Model       string    `gorm:"size:255;index:idx_probe_lookup" json:"model"` // 网关对外的模型名
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+	ProbeHour   int64     `gorm:"index" json:"-"` // Unix时间的小时数，状态页按它在数据库中分组汇总
+	Success     bool      `json:"success"`
+	StatusCode  int       `json:"status_code"`
+	Latency     float64   `json:"latency"` // 秒
+	ErrorClass  string    `gorm:"size:32" json:"error_class,omitempty"`
+	Snippet     string    `gorm:"type:text" json:"snippet,omitempty"`
+}
+
+func (ProbeRecord) TableName() string {
+	return "channel_monitor_probes"
+}
+
+// ProbeRollup 超过raw_retention的结果按downsample_interval汇总
+type ProbeRollup struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Target     string    `gorm:"size:64;uniqueIndex:idx_rollup_bucket" json:"target"`
+	ChannelID  int       `gorm:"uniqueIndex:idx_rollup_bucket" json:"channel_id"`
+	Model      string    `gorm:"size:255;uniqueIndex:idx_rollup_bucket" json:"model"`
+	Bucket     time.Time `gorm:"uniqueIndex:idx_rollup_bucket;index" json:"bucket"` // 时间段的开始
+	Total      int       `json:"total"`
+	Successes  int       `json:"successes"`
+	LatencySum float64   `json:"latency_sum"` // 成功探测的延迟之和
+	LatencyMax float64   `json:"latency_max"`
+}
+
+func (ProbeRollup) TableName() string {
+	return "channel_monitor_probe_rollups"
+}
+
+type rollupKey struct {
+	Target    string
+	ChannelID int
+	Model     string
+	Bucket    time.Time
+}
+
+// 每次汇总处理的原始结果数量
+const compactBatchSize = 5000
+
+var resultStore *gorm.DB
+
+// 连接结果存储并建表，未启用时resultStore为nil
+func initResultStore(cfg ResultStoreConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	db, err := NewDB(Config{DbType: cfg.DbType, DbDsn: cfg.DbDsn})
+	if err != nil {
+		return fmt.Errorf("连接结果存储失败: %v", err)
+	}
+	if err := db.AutoMigrate(&ProbeRecord{}, &ProbeRollup{}); err != nil {
+		return err
+	}
+	resultStore = db
+	go runResultStoreMaintenance(cfg)
+	return nil
+}
+
+// 保存一次探测结果，model为网关对外的模型名
+func (t *Target) recordProbe(channel Channel, model string, result ProbeResult) {
+	if resultStore == nil {
+		return
+	}
+	now := time.Now()
+	record := ProbeRecord{
+		Target:      t.Name,
+		ChannelID:   channel.ID,
+		ChannelName: channel.Name,
+		Model:       model,
+		CreatedAt:   now,
+		ProbeHour:   now.Unix() / 3600,
+		Success:     result.Success,
+		StatusCode:  result.StatusCode,
+		Latency:     result.Latency,
+		ErrorClass:  result.ErrorClass(),
+		Snippet:     truncateSnippet(result.Message, *currentConfig().ResultStore.SnippetLength),
+	}
+	startTime := time.Now()
+	err := resultStore.Create(&record).Error
+	dbOperationDuration.WithLabelValues(t.Name, "record_probe").Observe(time.Since(startTime).Seconds())
+	if err != nil {
+		dbOperationTotal.WithLabelValues(t.Name, "record_probe", "error").Inc()
+		log.Printf("\033[31m保存渠道 %s(ID:%d) 模型 %s 的探测结果失败：%v\033[0m\n", channel.Name, channel.ID, model, err)
+		return
+	}
+	dbOperationTotal.WithLabelValues(t.Name, "record_probe", "success").Inc()
+}
+
+// 按字符截断，避免截断在多字节字符中间
+func truncateSnippet(s string, length int) string {
+	if length <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= length {
+		return s
+	}
+	return string([]rune(s)[:length])
+}
+
+// 定期汇总过期的原始结果并删除过期的汇总
+func runResultStoreMaintenance(cfg ResultStoreConfig) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if err := compactResults(cfg, time.Now()); err != nil {
+			log.Printf("\033[31m整理探测结果失败：%v\033[0m\n", err)
+		}
+		<-ticker.C
+	}
+}
+
+func compactResults(cfg ResultStoreConfig, now time.Time) error {
+	rawRetention, _ := time.ParseDuration(cfg.RawRetention)
+	retention, _ := time.ParseDuration(cfg.Retention)
+	interval, _ := time.ParseDuration(cfg.DownsampleInterval)
+
+	rawCutoff := now.Add(-rawRetention)
+	compacted := 0
+	for {
+		n, err := compactBatch(rawCutoff, interval)
+		if err != nil {
+			return err
+		}
+		compacted += n
+		if n < compactBatchSize {
+			break
+		}
+	}
+
+	result := resultStore.Where("bucket < ?", now.Add(-retention)).Delete(&ProbeRollup{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if compacted > 0 || result.RowsAffected > 0 {
+		log.Printf("已汇总 %d 条探测结果，删除 %d 条过期的汇总\n", compacted, result.RowsAffected)
+	}
+	return nil
+}
+
+// 汇总一批早于cutoff的原始结果并删除，返回处理的数量
+func compactBatch(cutoff time.Time, interval time.Duration) (int, error) {
+	var records []ProbeRecord
+	err := resultStore.Where("created_at < ?", cutoff).Order("id").Limit(compactBatchSize).Find(&records).Error
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+
+	rollups := make(map[rollupKey]*ProbeRollup)
+	for _, r := range records {
+		key := rollupKey{Target: r.Target, ChannelID: r.ChannelID, Model: r.Model, Bucket: r.CreatedAt.UTC().Truncate(interval)}
+		rollup, ok := rollups[key]
+		if !ok {
+			rollup = &ProbeRollup{Target: key.Target, ChannelID: key.ChannelID, Model: key.Model, Bucket: key.Bucket}
+			rollups[key] = rollup
+		}
+		rollup.Total++
+		if r.Success {
+			rollup.Successes++
+			rollup.LatencySum += r.Latency
+			if r.Latency > rollup.LatencyMax {
+				rollup.LatencyMax = r.Latency
+			}
+		}
+	}
+
+	err = resultStore.Transaction(func(tx *gorm.DB) error {
+		for key, rollup := range rollups {
+			var existing ProbeRollup
+			err := tx.Where(map[string]interface{}{"target": key.Target, "channel_id": key.ChannelID, "model": key.Model, "bucket": key.Bucket}).
+				Limit(1).Find(&existing).Error
+			if err != nil {
+				return err
+			}
+			if existing.ID == 0 {
+				if err := tx.Create(rollup).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			existing.Total += rollup.Total
+			existing.Successes += rollup.Successes
+			existing.LatencySum += rollup.LatencySum
+			if rollup.LatencyMax > existing.LatencyMax {
+				existing.LatencyMax = rollup.LatencyMax
+			}
+			if err := tx.Save(&existing).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("id <= ? AND created_at < ?", records[len(records)-1].ID, cutoff).Delete(&ProbeRecord{}).Error
+	})
+	return len(records), err
+}
+
+// 设置默认值，配置由validateProcess检查
+func (cfg *ResultStoreConfig) setDefaults() {
+	if cfg.DbType == "" {
+		cfg.DbType = "sqlite"
+	}
+	if cfg.DbDsn == "" && cfg.DbType == "sqlite" {
+		cfg.DbDsn = "channel_monitor.db"
+	}
+	if cfg.RawRetention == "" {
+		cfg.RawRetention = "168h"
+	}
+	if cfg.Retention == "" {
+		cfg.Retention = "2160h"
+	}
+	if cfg.DownsampleInterval == "" {
+		cfg.DownsampleInterval = "1h"
+	}
+	// 使用指针，配置为0时不会被默认值覆盖
+	if cfg.SnippetLength == nil {
+		length := 256
+		cfg.SnippetLength = &length
+	}
+}
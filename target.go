@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+)
+
+// Target 一个被监控的网关及其运行状态，各网关之间互不影响
+type Target struct {
+	Name      string
+	DB        *gorm.DB // 未配置db_dsn时为nil
+	HistoryDB *gorm.DB // 未启用变更历史时为nil
+
+	// 当前的配置和后端，重新加载配置时整体替换，通过Config()和Backend()读取
+	settings atomic.Pointer[targetSettings]
+
+	// 串行化对网关的写入
+	updateMu sync.Mutex
+	// 串行化检测周期和手动触发的渠道测试
+	cycleMu    sync.Mutex
+	paused     atomic.Bool        // 暂停定时检测，手动触发不受影响
+	reschedule chan time.Duration // 重新加载配置后time_period变化时通知run
+	// 无法识别网关类型而强制演练，重新加载配置后仍然保持
+	dryRunForced bool
+
+	limitMu sync.Mutex
+	limits  map[int]*channelLimit
+
+	planMu      sync.Mutex
+	currentPlan *CyclePlan
+	lastPlan    *CyclePlan
+
+	routingMu      sync.Mutex
+	routingSamples map[routingKey][]probeSample
+
+	emptyMu       sync.Mutex
+	emptyDisabled map[int]bool // 本进程按empty_policy禁用的渠道，未启用变更历史时使用
+
+	findingsMu sync.Mutex
+	findings   map[int]string // 渠道上一周期按empty_policy处理和受保护而保留的模型，未变化时不重复通知
+
+	cacheMu      sync.Mutex
+	cacheChannel int // 最近一次直接写入数据库的渠道，0表示没有待刷新的写入
+
+	statusMu      sync.Mutex
+	channelStatus map[int]*channelState
+	modelStatus   map[string]*ModelSummary
+
+	pageMu      sync.Mutex
+	pageHistory *statusHistory // 状态页缓存的探测历史
+}
+
+// targetSettings 网关的配置和按配置创建的后端，发布后不再修改
+type targetSettings struct {
+	config  *Config
+	backend Backend
+}
+
+// Config 网关当前的配置，同一次操作中多次读取时应先保存到局部变量
+func (t *Target) Config() *Config {
+	return t.settings.Load().config
+}
+
+// Backend 网关当前的读写方式
+func (t *Target) Backend() Backend {
+	return t.settings.Load().backend
+}
+
+func (t *Target) setSettings(cfg *Config, backend Backend) {
+	t.settings.Store(&targetSettings{config: cfg, backend: backend})
+}
+
+// 以演练模式运行，替换为副本，不修改已发布的配置
+func (t *Target) forceDryRun() {
+	cfg := *t.Config()
+	cfg.DoNotModifyDb = true
+	t.setSettings(&cfg, t.Backend())
+}
+
+var targets []*Target
+
+// 连接网关的数据库或管理接口，识别网关类型并初始化变更历史
+func newTarget(cfg *Config) (*Target, error) {
+	t := &Target{
+		Name:           cfg.Name,
+		reschedule:     make(chan time.Duration, 1),
+		routingSamples: make(map[routingKey][]probeSample),
+		limits:         make(map[int]*channelLimit),
+		emptyDisabled:  make(map[int]bool),
+		findings:       make(map[int]string),
+		channelStatus:  make(map[int]*channelState),
+		modelStatus:    make(map[string]*ModelSummary),
+	}
+
+	// 未配置db_dsn时不连接数据库，完全通过管理接口读写渠道
+	if cfg.DbDsn != "" {
+		db, err := NewDB(*cfg)
+		if err != nil {
+			return nil, fmt.Errorf("数据库连接失败：%v", err)
+		}
+		t.DB = db
+	} else {
+		log.Printf("网关 %s 未配置db_dsn，通过管理接口读写渠道\n", t.Name)
+	}
+
+	dryRun := cfg.DoNotModifyDb
+	resolveBackend(cfg, t.DB)
+	t.dryRunForced = cfg.DoNotModifyDb && !dryRun
+	backend, err := newBackend(cfg, t.DB)
+	if err != nil {
+		return nil, fmt.Errorf("初始化网关失败：%v", err)
+	}
+	t.setSettings(cfg, backend)
+
+	if err := t.initHistory(); err != nil {
+		return nil, fmt.Errorf("初始化变更历史失败：%v", err)
+	}
+	return t, nil
+}
+
+// 按名称查找网关，只有一个网关时名称可以省略
+func findTarget(name string) (*Target, error) {
+	if name == "" && len(targets) == 1 {
+		return targets[0], nil
+	}
+	for _, t := range targets {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("配置了多个网关，需要指定target")
+	}
+	return nil, fmt.Errorf("找不到网关 %s", name)
+}
+
+// 从请求参数target中查找网关，找不到时返回错误响应
+func targetFromRequest(w http.ResponseWriter, r *http.Request) (*Target, bool) {
+	t, err := findTarget(r.URL.Query().Get("target"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	return t, true
+}
+
+// channelLimit 单个渠道的并发数和每秒请求数限制
+type channelLimit struct {
+	sem     chan struct{}
+	limiter *rate.Limiter
+}
+
+// 返回渠道的并发信号量和限流器，同一渠道的所有测试共用
+func (t *Target) channelLimits(channelID int) (chan struct{}, *rate.Limiter) {
+	t.limitMu.Lock()
+	defer t.limitMu.Unlock()
+	limit, ok := t.limits[channelID]
+	if !ok {
+		cfg := t.Config()
+		limit = &channelLimit{
+			sem:     make(chan struct{}, cfg.MaxConcurrent),
+			limiter: rate.NewLimiter(rate.Limit(cfg.RPS), cfg.RPS),
+		}
+		t.limits[channelID] = limit
+	}
+	return limit.sem, limit.limiter
+}
+
+// 按time_period定期检测
+func (t *Target) run() {
+	duration, err := time.ParseDuration(t.Config().TimePeriod)
+	if err != nil {
+		log.Printf("\033[31m网关 %s 解析时间周期失败：%v\033[0m\n", t.Name, err)
+		return
+	}
+
+	ticker := time.NewTicker(duration)
+	defer ticker.Stop()
+
+	for {
+		if t.paused.Load() {
+			log.Printf("网关 %s 的定时检测已暂停，跳过本周期\n", t.Name)
+		} else {
+			t.runCycle()
+		}
+
+		// 等待下一个周期，等待期间time_period变化时按新的周期重新计时
+		for waiting := true; waiting; {
+			select {
+			case <-ticker.C:
+				waiting = false
+			case d := <-t.reschedule:
+				ticker.Reset(d)
+				log.Printf("网关 %s 的检测周期已调整为 %v\n", t.Name, d)
+			}
+		}
+	}
+}
+
+// ChannelReport 单个渠道的测试结果
+type ChannelReport struct {
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	Skipped   bool              `json:"skipped"`
+	Tested    int               `json:"tested"`
+	Available []string          `json:"available"`
+	Failures  map[string]string `json:"failures,omitempty"`
+}
+
+// CycleReport 一次检测的结果
+type CycleReport struct {
+	Target     string          `json:"target"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Error      string          `json:"error,omitempty"`
+	Blocked    bool            `json:"blocked"` // 被outage_guard拦截，未写入
+	Plan       *CyclePlan      `json:"plan,omitempty"`
+	Channels   []ChannelReport `json:"channels"`
+}
+
+func (t *Target) newCycleReport(start time.Time, results []ChannelResult) *CycleReport {
+	report := &CycleReport{Target: t.Name, StartedAt: start, FinishedAt: time.Now(), Channels: []ChannelReport{}}
+	for _, r := range results {
+		available := make([]string, 0, len(r.Available))
+		for _, model := range r.Available {
+			available = append(available, publicModelName(r.ModelMapping, model))
+		}
+		failures := make(map[string]string)
+		for model, class := range r.Failures {
+			failures[publicModelName(r.ModelMapping, model)] = class
+		}
+		report.Channels = append(report.Channels, ChannelReport{
+			ID:        r.Channel.ID,
+			Name:      r.Channel.Name,
+			Skipped:   r.Skipped,
+			Tested:    r.Tested,
+			Available: available,
+			Failures:  failures,
+		})
+	}
+	sort.Slice(report.Channels, func(i, j int) bool { return report.Channels[i].ID < report.Channels[j].ID })
+	t.planMu.Lock()
+	report.Plan = t.lastPlan
+	t.planMu.Unlock()
+	return report
+}
+
+// 并发测试渠道的所有模型，只有定时检测周期推送UptimeKuma
+func (t *Target) testChannels(channels []Channel, pushUptime bool) []ChannelResult {
+	var wg sync.WaitGroup
+	var resultsMu sync.Mutex
+	var results []ChannelResult
+	for _, channel := range channels {
+		wg.Add(1)
+		go t.testModels(channel, pushUptime, &wg, &resultsMu, &results)
+	}
+	wg.Wait()
+	return results
+}
+
+// 执行一个完整的检测周期：测试、计算变更并写入
+func (t *Target) runCycle() *CycleReport {
+	t.cycleMu.Lock()
+	defer t.cycleMu.Unlock()
+
+	cycleStart := time.Now()
+	log.Printf("网关 %s 开始检测...\n", t.Name)
+	t.beginPlan()
+
+	channels, err := t.fetchChannels()
+	if err != nil {
+		log.Printf("\033[31m网关 %s 获取渠道失败：%v\033[0m\n", t.Name, err)
+		t.finishPlan()
+		report := t.newCycleReport(cycleStart, nil)
+		report.Error = err.Error()
+		return report
+	}
+
+	results := t.testChannels(channels, true)
+	t.finishStatus(results, cycleStart, true)
+
+	// 失败比例过高时疑似监控端故障，不写入数据库
+	blocked := t.checkOutageGuard(results)
+	t.updateChannels(results, blocked)
+	t.finishPlan()
+
+	// 根据探测结果调整优先级与权重
+	if !t.Config().DoNotModifyDb && !blocked {
+		t.applyRoutingAdvice()
+	}
+
+	// 记录测试周期指标
+	testCycleDuration.WithLabelValues(t.Name).Observe(time.Since(cycleStart).Seconds())
+	testCycleTotal.WithLabelValues(t.Name).Inc()
+
+	log.Printf("网关 %s 测试周期完成，耗时：%v\n", t.Name, time.Since(cycleStart))
+	report := t.newCycleReport(cycleStart, results)
+	report.Blocked = blocked
+	return report
+}
+
+var errChannelNotFound = errors.New("找不到渠道，或渠道在排除列表中")
+
+// 立即测试单个渠道并按结果更新，不经过outage_guard
+func (t *Target) testChannel(channelID int) (*CycleReport, error) {
+	t.cycleMu.Lock()
+	defer t.cycleMu.Unlock()
+
+	channel, err := t.findChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	log.Printf("网关 %s 手动测试渠道 %s(ID:%d)\n", t.Name, channel.Name, channel.ID)
+	t.beginPlan()
+	results := t.testChannels([]Channel{channel}, false)
+	t.finishStatus(results, start, false)
+	t.updateChannels(results, false)
+	t.finishPlan()
+	return t.newCycleReport(start, results), nil
+}
+
+// ProbeReport 单个渠道单个模型的测试结果
+type ProbeReport struct {
+	Target     string  `json:"target"`
+	ChannelID  int     `json:"channel_id"`
+	Model      string  `json:"model"`
+	Success    bool    `json:"success"`
+	StatusCode int     `json:"status_code"`
+	Latency    float64 `json:"latency"`
+	ErrorClass string  `json:"error_class,omitempty"`
+	Message    string  `json:"message,omitempty"`
+}
+
+// 立即测试渠道上的一个模型，只记录结果，不写入网关。model为网关对外的模型名
+func (t *Target) testChannelModel(channelID int, model string) (*ProbeReport, error) {
+	channel, err := t.findChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	upstream := model
+	if t.probeModeFor(channel) != ProbeGateway {
+		if mapped, ok := channel.ModelMapping[model]; ok {
+			upstream = mapped
+		}
+	}
+
+	sem, limiter := t.channelLimits(channel.ID)
+	sem <- struct{}{}
+	limiter.Wait(context.Background())
+	result := t.probeModel(channel, upstream)
+	<-sem
+
+	t.recordProbe(channel, model, result)
+	t.recordModelStatus(channel, model, result)
+	// 只更新这一个模型，渠道的其他模型保留上一次的结果
+	t.finishStatus([]ChannelResult{{Channel: channel}}, time.Time{}, false)
+	return &ProbeReport{
+		Target:     t.Name,
+		ChannelID:  channel.ID,
+		Model:      model,
+		Success:    result.Success,
+		StatusCode: result.StatusCode,
+		Latency:    result.Latency,
+		ErrorClass: result.ErrorClass(),
+		Message:    result.Message,
+	}, nil
+}
+
+// 按ID查找需要监控的渠道
+func (t *Target) findChannel(channelID int) (Channel, error) {
+	channels, err := t.fetchChannels()
+	if err != nil {
+		return Channel{}, err
+	}
+	for _, channel := range channels {
+		if channel.ID == channelID {
+			return channel, nil
+		}
+	}
+	return Channel{}, errChannelNotFound
+}
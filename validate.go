@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError 配置中的所有错误和警告
+type ConfigError struct {
+	Errors   []string
+	Warnings []string
+}
+
+func (e *ConfigError) Error() string {
+	return "配置有误:\n" + strings.Join(e.Errors, "\n")
+}
+
+func (e *ConfigError) errorf(format string, args ...interface{}) {
+	e.Errors = append(e.Errors, fmt.Sprintf(format, args...))
+}
+
+func (e *ConfigError) warnf(format string, args ...interface{}) {
+	e.Warnings = append(e.Warnings, fmt.Sprintf(format, args...))
+}
+
+// 字段类型不符时解析会跳过该字段，其余配置仍然有效
+func isTypeError(err error) bool {
+	var yamlErr *yaml.TypeError
+	var jsonErr *json.UnmarshalTypeError
+	return errors.As(err, &yamlErr) || errors.As(err, &jsonErr)
+}
+
+// 找出配置文件中Config没有的配置项，返回带路径的错误
+func checkUnknownFields(data []byte, isYAML bool) []string {
+	var tree interface{}
+	var err error
+	if isYAML {
+		err = yaml.Unmarshal(data, &tree)
+	} else {
+		err = json.Unmarshal(data, &tree)
+	}
+	if err != nil {
+		return nil
+	}
+	tag := "json"
+	if isYAML {
+		tag = "yaml"
+	}
+	var problems []string
+	findUnknownFields(tree, reflect.TypeOf(Config{}), "", tag, &problems)
+	return problems
+}
+
+func findUnknownFields(v interface{}, typ reflect.Type, path, tag string, problems *[]string) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			// 类型不对时由解析配置文件时报错
+			return
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			name := strings.Split(f.Tag.Get(tag), ",")[0]
+			if f.PkgPath != "" || name == "" || name == "-" {
+				continue
+			}
+			fields[name] = f.Type
+			if name == "targets" {
+				// 每个网关可以设置顶层的所有配置项
+				fields[name] = reflect.TypeOf([]Config{})
+			}
+		}
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fieldType, ok := fields[key]
+			if !ok {
+				msg := fmt.Sprintf("未知的配置项: %s%s", path, key)
+				if suggestion := suggestKey(key, fields); suggestion != "" {
+					msg += fmt.Sprintf("，是否为%s", suggestion)
+				}
+				*problems = append(*problems, msg)
+				continue
+			}
+			findUnknownFields(m[key], fieldType, path+key+".", tag, problems)
+		}
+	case reflect.Map:
+		if m, ok := v.(map[string]interface{}); ok {
+			for key, item := range m {
+				findUnknownFields(item, typ.Elem(), path+key+".", tag, problems)
+			}
+		}
+	case reflect.Slice:
+		if list, ok := v.([]interface{}); ok {
+			prefix := strings.TrimSuffix(path, ".")
+			for i, item := range list {
+				findUnknownFields(item, typ.Elem(), fmt.Sprintf("%s[%d].", prefix, i), tag, problems)
+			}
+		}
+	}
+}
+
+// 返回与key最接近的配置项，用于提示拼写错误
+func suggestKey(key string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", len(key)/3+1
+	for name := range fields {
+		if d := editDistance(key, name); d < bestDistance || (d == bestDistance && best != "" && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func validDuration(value string) bool {
+	d, err := time.ParseDuration(value)
+	return err == nil && d > 0
+}
+
+func validURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// 监听地址，如:2112或127.0.0.1:2112
+func validListenAddr(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && validPort(n)
+}
+
+// 两个监听地址是否使用同一个端口，metrics_port为空时为:2112
+func samePort(addr, metricsPort string) bool {
+	if metricsPort == "" {
+		metricsPort = ":2112"
+	}
+	_, a, _ := net.SplitHostPort(addr)
+	_, b, _ := net.SplitHostPort(metricsPort)
+	return a == b
+}
+
+func validDbType(dbType string) bool {
+	return containsString([]string{"mysql", "sqlite", "postgres", "sqlserver"}, dbType)
+}
+
+// 检查整个进程共用的配置
+func (c *Config) validateProcess(problems *ConfigError) {
+	c.ResultStore.setDefaults()
+	if store := c.ResultStore; store.Enabled {
+		if !validDbType(store.DbType) {
+			problems.errorf("result_store.db_type无效: %s，可选mysql、sqlite、postgres、sqlserver", store.DbType)
+		}
+		if *store.SnippetLength < 0 {
+			problems.errorf("result_store.snippet_length不能为负数: %d", *store.SnippetLength)
+		}
+		durations := make(map[string]time.Duration)
+		for _, item := range []struct{ name, value string }{
+			{"raw_retention", store.RawRetention},
+			{"retention", store.Retention},
+			{"downsample_interval", store.DownsampleInterval},
+		} {
+			d, err := time.ParseDuration(item.value)
+			if err != nil || d <= 0 {
+				problems.errorf("result_store.%s无效: %s", item.name, item.value)
+				continue
+			}
+			durations[item.name] = d
+		}
+		// 汇总结果由过期的原始结果生成，保留时间更短时汇总生成后会立即被删除
+		raw, rawOK := durations["raw_retention"]
+		if retention, ok := durations["retention"]; ok && rawOK && retention < raw {
+			problems.errorf("result_store.retention(%s)不能小于raw_retention(%s)", store.Retention, store.RawRetention)
+		}
+	}
+	if c.StatusPage.Enabled && !c.ResultStore.Enabled {
+		problems.errorf("status_page需要启用result_store")
+	}
+	if !validListenAddr(c.StatusPage.Listen) {
+		problems.errorf("status_page.listen无效: %s，应为:2113或127.0.0.1:2113的格式", c.StatusPage.Listen)
+	} else if c.StatusPage.Enabled && c.metricsEnabled() && samePort(c.StatusPage.Listen, c.MetricsPort) {
+		problems.errorf("status_page.listen不能与metrics_port相同: %s", c.StatusPage.Listen)
+	}
+	for id := range c.StatusPage.ChannelNames {
+		if _, err := strconv.Atoi(id); err != nil {
+			problems.errorf("status_page.channel_names的键应为渠道ID: %s", id)
+		}
+	}
+	if err := validateRunOnce(c.RunOnce); err != nil {
+		problems.errorf("%v", err)
+	}
+	if d, err := time.ParseDuration(c.ConfigWatchInterval); err != nil || d < 0 {
+		problems.errorf("config_watch_interval无效: %s", c.ConfigWatchInterval)
+	}
+
+	if c.MetricsPort != "" && !validListenAddr(c.MetricsPort) {
+		problems.errorf("metrics_port无效: %s，应为:2112或127.0.0.1:2112的格式", c.MetricsPort)
+	}
+	if !c.metricsEnabled() && c.ControlToken != "" {
+		problems.warnf("metrics_enabled为false时不启动HTTP服务，control_token不生效")
+	}
+
+	if c.PushGateway.Enabled {
+		if !validURL(c.PushGateway.URL) {
+			problems.errorf("push_gateway.url无效: %s", c.PushGateway.URL)
+		}
+		if c.PushGateway.Job == "" {
+			problems.errorf("push_gateway需要配置job")
+		}
+	}
+	if c.PushGateway.Interval != "" && !validDuration(c.PushGateway.Interval) {
+		problems.errorf("push_gateway.interval无效: %s", c.PushGateway.Interval)
+	}
+
+	smtp := c.Notification.SMTP
+	if smtp.Enabled {
+		if smtp.Host == "" || smtp.From == "" || smtp.To == "" {
+			problems.errorf("notification.smtp需要配置host、from和to")
+		}
+		if !validPort(smtp.Port) {
+			problems.errorf("notification.smtp.port无效: %d", smtp.Port)
+		}
+	}
+	webhook := c.Notification.Webhook
+	if webhook.Type != "" && webhook.Type != "telegram" {
+		problems.errorf("notification.webhook.type无效: %s，目前只支持telegram", webhook.Type)
+	}
+	if webhook.Enabled {
+		if webhook.Type == "" {
+			problems.errorf("notification.webhook需要配置type")
+		}
+		if webhook.Type == "telegram" && (webhook.Secret == "" || webhook.Telegram.ChatID == "") {
+			problems.errorf("notification.webhook为telegram时需要配置secret和telegram.chat_id")
+		}
+		if webhook.Telegram.Retry <= 0 {
+			problems.warnf("notification.webhook.telegram.retry为%d，不会发送通知", webhook.Telegram.Retry)
+		}
+	}
+
+	if len(c.Targets) > 0 && c.Name != "" {
+		problems.warnf("配置了targets时顶层的name不生效")
+	}
+}
+
+// 检查一个网关的配置，需要先调用setDefaults
+func (c *Config) validate(problems *ConfigError, prefix string) {
+	errorf := func(format string, args ...interface{}) {
+		problems.errorf(prefix+format, args...)
+	}
+	warnf := func(format string, args ...interface{}) {
+		problems.warnf(prefix+format, args...)
+	}
+
+	if !validDuration(c.TimePeriod) {
+		errorf("time_period无效: %q", c.TimePeriod)
+	}
+	switch c.OneAPIType {
+	case BackendAuto, BackendOneAPI, BackendNewAPI, BackendOneHub, BackendVoAPI, BackendREST:
+	default:
+		errorf("oneapi_type无效: %s，可选auto、oneapi、newapi、onehub、voapi、rest", c.OneAPIType)
+	}
+	if c.DbDsn != "" && !validDbType(c.DbType) {
+		errorf("db_type无效: %s，可选mysql、sqlite、postgres、sqlserver", c.DbType)
+	}
+	if c.History.DbType != "" && !validDbType(c.History.DbType) {
+		errorf("history.db_type无效: %s", c.History.DbType)
+	}
+	if c.History.Enabled && c.History.DbType == "" && c.DbDsn == "" {
+		errorf("未配置db_dsn时需要为history单独配置db_type和db_dsn")
+	}
+	if c.BaseURL != "" && !validURL(c.BaseURL) {
+		errorf("base_url无效: %s", c.BaseURL)
+	}
+	// 不连接数据库时通过管理接口读写渠道
+	if c.DbDsn == "" && (c.BaseURL == "" || c.SystemToken == "") {
+		errorf("未配置db_dsn时需要配置base_url和system_token")
+	}
+	for name, value := range map[string]int{"max_concurrent": c.MaxConcurrent, "rps": c.RPS, "timeout": c.Timeout, "admin_user_id": c.AdminUserID} {
+		if value < 0 {
+			errorf("%s不能为负数: %d", name, value)
+		}
+	}
+
+	if c.Probe.Default != ProbeDirect && c.Probe.Default != ProbeGateway {
+		errorf("未知的探测方式: %s", c.Probe.Default)
+	}
+	for channelType, mode := range c.Probe.ChannelType {
+		if mode != ProbeDirect && mode != ProbeGateway {
+			errorf("probe.channel_type.%s的探测方式无效: %s", channelType, mode)
+		}
+	}
+	// 管理接口不返回渠道的密钥，直接探测上游时所有请求都会因鉴权失败而移除模型
+	if c.DbDsn == "" {
+		if c.Probe.Default == ProbeDirect {
+			errorf("未配置db_dsn时无法读取渠道密钥，probe.default不能为direct")
+		}
+		for channelType, mode := range c.Probe.ChannelType {
+			if mode == ProbeDirect {
+				errorf("未配置db_dsn时无法读取渠道密钥，probe.channel_type.%s不能为direct", channelType)
+			}
+		}
+	}
+	if c.AbilitiesPolicy != AbilitiesPolicyDisable && c.AbilitiesPolicy != AbilitiesPolicyDelete {
+		errorf("未知的abilities处理方式: %s", c.AbilitiesPolicy)
+	}
+	if c.EmptyPolicy != EmptyPolicyKeep && c.EmptyPolicy != EmptyPolicyDisable && c.EmptyPolicy != EmptyPolicyClear {
+		errorf("未知的empty_policy: %s", c.EmptyPolicy)
+	}
+	if err := validateCacheReload(c.CacheReload, c); err != nil {
+		errorf("%v", err)
+	}
+	if c.RoutingAdvisor.Enabled {
+		if c.DbDsn == "" {
+			errorf("routing_advisor需要配置db_dsn")
+		}
+		// 通过管理接口读写的网关不会从数据库重新加载优先级和权重
+		if c.OneAPIType == BackendOneHub || c.OneAPIType == BackendVoAPI || c.OneAPIType == BackendREST {
+			errorf("oneapi_type为%s时不支持routing_advisor，只支持oneapi和newapi", c.OneAPIType)
+		}
+		if err := validateRoutingAdvisor(c.RoutingAdvisor); err != nil {
+			errorf("%v", err)
+		}
+		// touch保存渠道时网关按渠道的priority重新生成abilities并刷新缓存，之后恢复的按模型优先级不会进入缓存
+		if c.RoutingAdvisor.Mode == RoutingModeAbility && c.CacheReload.Mode == CacheReloadTouch {
+			errorf("routing_advisor.mode为ability时不能使用cache_reload: touch")
+		}
+	}
+
+	guard := c.OutageGuard
+	for _, ratio := range []*float64{guard.MaxModelFailureRatio, guard.MaxChannelFailureRatio} {
+		if ratio != nil && (*ratio < 0 || *ratio > 1) {
+			errorf("outage_guard的失败比例需要在0到1之间")
+			break
+		}
+	}
+	for _, u := range guard.CheckURLs {
+		if !validURL(u) {
+			errorf("outage_guard.check_urls中的地址无效: %s", u)
+		}
+	}
+
+	for id := range c.ChannelProtectedModels {
+		if _, err := strconv.Atoi(id); err != nil {
+			errorf("channel_protected_models的键应为渠道ID: %s", id)
+		}
+	}
+
+	kuma := c.UptimeKuma
+	if kuma.Status != "enabled" && kuma.Status != "disabled" {
+		errorf("uptime-kuma.status无效: %s，可选enabled、disabled", kuma.Status)
+	}
+	for _, urls := range []map[string]string{kuma.ModelURL, kuma.ChannelURL} {
+		for name, u := range urls {
+			if !validURL(u) {
+				errorf("uptime-kuma中%s的地址无效: %s", name, u)
+			}
+		}
+	}
+	if kuma.Status == "enabled" && len(kuma.ModelURL) == 0 && len(kuma.ChannelURL) == 0 {
+		warnf("uptime-kuma已启用，但没有配置model_url和channel_url")
+	}
+
+	// 相互矛盾的配置
+	if c.ForceModels && len(c.Models) == 0 {
+		warnf("force_models为true但models为空，不会测试任何模型")
+	}
+	if c.ForceModels && c.ForceInsideModels {
+		warnf("force_models为true时force_inside_models不生效")
+	}
+	for _, model := range c.Models {
+		if containsString(c.ExcludeModel, model) {
+			warnf("模型 %s 同时出现在models和exclude_model中，exclude_model只过滤/v1/models返回的模型，force_models为true或无法获取模型列表时仍会测试该模型", model)
+		}
+	}
+	for _, model := range c.ProtectedModels {
+		if containsString(c.ExcludeModel, model) {
+			warnf("模型 %s 同时出现在protected_models和exclude_model中", model)
+		}
+	}
+	if c.History.DbDsn != "" && c.History.DbType == "" {
+		warnf("history.db_type为空时使用网关的数据库，history.db_dsn不生效")
+	}
+	if c.DoNotModifyDb && c.RoutingAdvisor.Enabled {
+		warnf("do_not_modify_db为true时routing_advisor不生效")
+	}
+}